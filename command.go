@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/i18n"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/routers"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command]
+
+Commands:
+  (none)                 start http server
+  migrate up             apply all pending migrations
+  migrate down [n]       roll back the last n migrations, default 1
+  migrate status         show migration status
+  migrate create <name>  create sql migration files in DBMigrationPath
+  seed apply [env]       apply seed data in SeedPath/<env>, default env is AppRunMode
+  openapi dump           print the OpenAPI document generated from the routes
+  openapi check          exit 1 if a route is not documented or a document has no route
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runCommand 执行子命令, 返回退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "seed":
+		return runSeed(args[1:])
+	case "openapi":
+		return runOpenAPI(args[1:])
+	}
+	flag.Usage()
+	return 2
+}
+
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		flag.Usage()
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := models.MigrateUp()
+		fmt.Printf("applied %d migrations\n", count)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "invalid steps:", args[1])
+				return 2
+			}
+			steps = n
+		}
+		count, err := models.MigrateDown(steps)
+		fmt.Printf("rolled back %d migrations\n", count)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "status":
+		statuses, err := models.MigrateStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Missing {
+				state += " (missing)"
+			}
+			fmt.Printf("%s  %-30s  %s\n", s.Version, s.Name, state)
+		}
+
+	case "create":
+		if len(args) < 2 {
+			flag.Usage()
+			return 2
+		}
+		paths, err := models.MigrateCreate(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+
+	default:
+		flag.Usage()
+		return 2
+	}
+	return 0
+}
+
+func runSeed(args []string) int {
+	if len(args) == 0 || args[0] != "apply" {
+		flag.Usage()
+		return 2
+	}
+
+	env := config.AppConfig.AppRunMode
+	if len(args) > 1 {
+		env = args[1]
+	}
+	if err := models.SeedEnv(env); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("applied seed", env)
+	return 0
+}
+
+func runOpenAPI(args []string) int {
+	if len(args) == 0 || (args[0] != "dump" && args[0] != "check") {
+		flag.Usage()
+		return 2
+	}
+
+	i18n.InitI18n()
+	gin.SetMode(gin.ReleaseMode)
+	doc, problems := routers.BuildAPIDoc(routers.InitGinEngine())
+	if args[0] == "dump" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(doc); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) != 0 {
+		return 1
+	}
+	fmt.Println("all routes documented")
+	return 0
+}
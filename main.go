@@ -4,24 +4,56 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/avatar"
 	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/i18n"
 	"github.com/sulin2018/go-web-base/src/app/log"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+	"github.com/sulin2018/go-web-base/src/app/upload"
+	"github.com/sulin2018/go-web-base/src/dto"
 	"github.com/sulin2018/go-web-base/src/middleware"
 	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/presenter"
 	"github.com/sulin2018/go-web-base/src/routers"
 )
 
-func init() {
-	config.InitConfig(*flag.String("config", "config.yaml", "config file path"))
+var configFile = flag.String("config", "config.yaml", "config file path")
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	config.InitConfig(*configFile)
 	log.InitLogrus()
+	// 生成接口文档不需要数据库
+	if flag.NArg() != 0 && flag.Arg(0) == "openapi" {
+		os.Exit(runOpenAPI(flag.Args()[1:]))
+	}
 	models.DBInit()
+
+	// 子命令, 执行完退出
+	if flag.NArg() != 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
+	if err := models.CheckMigrate(); err != nil {
+		logrus.Fatalln(err)
+	}
+	models.InitAuth()
 	middleware.InitSessionStore()
-}
+	storage.InitStorage()
+	avatar.InitAvatarStore()
+	upload.InitUpload()
+	i18n.InitI18n()
+	dto.InitValidator()
+	if err := presenter.CheckSecrets(); err != nil {
+		logrus.Fatalln(err)
+	}
 
-func main() {
 	if config.AppConfig.AppRunMode == "dev" {
 		gin.SetMode(gin.DebugMode)
 	} else {
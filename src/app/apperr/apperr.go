@@ -0,0 +1,112 @@
+package apperr
+
+import (
+	"net/http"
+)
+
+// AppError 接口返回的错误
+// Code 为稳定的错误码, 客户端按其判断错误类型; Message 为消息目录中没有 Key 时的默认提示
+// Key 为消息目录中的标识, 为空时为 error.<Code>, Params 为消息中的参数, 一起返回给客户端以便自行翻译
+// Cause 为内部原因(如数据库错误), 只记录日志, 不返回给客户端
+type AppError struct {
+	Status  int
+	Code    string
+	Message string
+	Key     string
+	Params  map[string]interface{}
+	Details []FieldError
+	Cause   error
+}
+
+// FieldError 字段级错误, 如参数校验失败的字段, 消息标识为 field.<Code>
+type FieldError struct {
+	Field   string                 `json:"field"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+func (e FieldError) MessageKey() string {
+	return "field." + e.Code
+}
+
+func New(status int, code string, message string) *AppError {
+	return &AppError{Status: status, Code: code, Message: message}
+}
+
+func (e *AppError) Error() string {
+	if e.Cause != nil {
+		return e.Code + ": " + e.Cause.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Cause
+}
+
+// Is 错误码相同即视为同一错误, 可用 errors.Is(err, apperr.ErrNotFound) 判断
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Code == e.Code
+}
+
+func (e *AppError) MessageKey() string {
+	if e.Key != "" {
+		return e.Key
+	}
+	return "error." + e.Code
+}
+
+// 以下方法返回副本, 预定义的错误不会被修改
+
+func (e *AppError) WithCause(cause error) *AppError {
+	err := *e
+	err.Cause = cause
+	return &err
+}
+
+// WithKey 使用更具体的提示, 如 ErrInvalidParam.WithKey("error.too_many_rows", map[string]interface{}{"max": 1000})
+func (e *AppError) WithKey(key string, params map[string]interface{}) *AppError {
+	err := *e
+	err.Key = key
+	err.Params = params
+	return &err
+}
+
+func (e *AppError) WithDetails(details ...FieldError) *AppError {
+	err := *e
+	err.Details = append(append([]FieldError{}, e.Details...), details...)
+	return &err
+}
+
+// 通用错误
+var (
+	ErrBadRequest      = New(http.StatusBadRequest, "bad_request", "参数有误")
+	ErrInvalidID       = New(http.StatusBadRequest, "invalid_id", "ID错误")
+	ErrInvalidParam    = New(http.StatusBadRequest, "invalid_param", "参数错误")
+	ErrInvalidQuery    = New(http.StatusBadRequest, "invalid_query", "查询参数错误")
+	ErrValidation      = New(http.StatusUnprocessableEntity, "validation_failed", "参数校验失败")
+	ErrUnauthorized    = New(http.StatusUnauthorized, "unauthorized", "缺少认证信息")
+	ErrForbidden       = New(http.StatusForbidden, "forbidden", "无权限")
+	ErrNotFound        = New(http.StatusNotFound, "not_found", "数据不存在")
+	ErrRouteNotFound   = New(http.StatusNotFound, "route_not_found", "接口不存在")
+	ErrConflict        = New(http.StatusConflict, "conflict", "数据冲突")
+	ErrDuplicate       = New(http.StatusConflict, "duplicate", "数据已存在")
+	ErrReferenced      = New(http.StatusConflict, "referenced", "数据被引用或引用的数据不存在")
+	ErrGone            = New(http.StatusGone, "gone", "数据已过期")
+	ErrVersionConflict = New(http.StatusPreconditionFailed, "version_conflict", "数据已被修改, 请刷新后重试")
+	ErrVersionRequired = New(http.StatusPreconditionRequired, "version_required", "缺少If-Match版本信息")
+	ErrTooLarge        = New(http.StatusRequestEntityTooLarge, "too_large", "数据过大")
+	ErrInternal        = New(http.StatusInternalServerError, "internal", "服务器出错")
+	ErrNotImplemented  = New(http.StatusNotImplemented, "not_implemented", "服务器未实现")
+)
+
+// 业务错误
+var (
+	ErrLoginFailed   = New(http.StatusBadRequest, "login_failed", "账号或密码错误")
+	ErrWrongPassword = New(http.StatusBadRequest, "wrong_password", "原密码错误")
+	ErrUsernameTaken = New(http.StatusConflict, "username_taken", "用户名已被占用")
+	ErrNotDeleted    = New(http.StatusNotFound, "not_deleted", "数据不存在或未删除")
+	ErrImportFailed  = New(http.StatusBadRequest, "import_failed", "导入失败, 未写入任何数据")
+)
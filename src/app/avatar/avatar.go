@@ -0,0 +1,153 @@
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+// 允许的扩展名及其对应的真实文件类型
+var allowExt = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+}
+
+// 解码前限制像素, 防止解压炸弹
+const maxPixels = 4096 * 4096
+
+var keyRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var (
+	ErrTooLarge     = errors.New("avatar file too large")
+	ErrExtNotAllow  = errors.New("avatar file ext not allow")
+	ErrTypeNotAllow = errors.New("avatar file type not allow")
+	ErrBadImage     = errors.New("avatar image invalid")
+)
+
+var store storage.Storage
+
+func InitAvatarStore() {
+	logrus.Trace("init avatar store")
+	var err error
+	store, err = storage.New(config.AppConfig.StorageType, config.AppConfig.AvatarPath)
+	if err != nil {
+		logrus.Panicln(err)
+	}
+	logrus.Trace("init avatar store complate")
+}
+
+// SetStore 替换存储后端, 如测试时使用 storage.Memory
+func SetStore(s storage.Storage) {
+	store = s
+}
+
+func GetStore() storage.Storage {
+	return store
+}
+
+// FileName 缩略图文件名
+func FileName(key string, size int) string {
+	return fmt.Sprintf("%s_%d.png", key, size)
+}
+
+func ValidKey(key string) bool {
+	return keyRegexp.MatchString(key)
+}
+
+func ValidSize(size int) bool {
+	for _, s := range config.AppConfig.AvatarSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Save 校验上传的图片, 生成各尺寸缩略图并存储, 返回头像key
+func Save(fileHeader *multipart.FileHeader) (string, error) {
+	maxSize := config.AppConfig.AvatarMaxSize * 1024
+	if fileHeader.Size > maxSize {
+		return "", ErrTooLarge
+	}
+
+	ext := strings.ToLower(utils.GetExt(fileHeader.Filename))
+	contentType, ok := allowExt[ext]
+	if !ok {
+		return "", ErrExtNotAllow
+	}
+
+	f, err := fileHeader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// 多读一个字节判断是否超限, 不信任客户端给出的大小
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > maxSize {
+		return "", ErrTooLarge
+	}
+
+	if http.DetectContentType(data) != contentType {
+		return "", ErrTypeNotAllow
+	}
+
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || imgConfig.Width*imgConfig.Height > maxPixels {
+		return "", ErrBadImage
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrBadImage
+	}
+
+	hash := sha256.Sum256(data)
+	key := hex.EncodeToString(hash[:])
+	for _, size := range config.AppConfig.AvatarSizes {
+		var buf bytes.Buffer
+		err = png.Encode(&buf, utils.Thumbnail(img, size))
+		if err != nil {
+			return "", err
+		}
+		err = store.Put(FileName(key, size), &buf, int64(buf.Len()), "image/png")
+		if err != nil {
+			logrus.Error(err)
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// Remove 删除头像所有尺寸的缩略图
+func Remove(key string) error {
+	for _, size := range config.AppConfig.AvatarSizes {
+		err := store.Delete(FileName(key, size))
+		if err != nil {
+			logrus.Error(err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"testing"
+
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+)
+
+// fileHeader 通过 multipart 表单构造上传文件, 与 c.FormFile 得到的一致
+func fileHeader(t *testing.T, filename string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.File["file"][0]
+}
+
+func pngData(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// hugePNG 只有 IHDR 的图片, 声明的像素超过 maxPixels
+func hugePNG() []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], 5000)
+	binary.BigEndian.PutUint32(ihdr[8:], 5000)
+	ihdr[12], ihdr[13] = 8, 6 // 8位 RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)-4))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func setup(t *testing.T) *storage.Memory {
+	old, oldStore := config.AppConfig, store
+	t.Cleanup(func() { config.AppConfig, store = old, oldStore })
+
+	config.AppConfig.AvatarMaxSize = 64
+	config.AppConfig.AvatarSizes = []int{32, 128}
+	memory := storage.NewMemory()
+	SetStore(memory)
+	return memory
+}
+
+func TestSaveResize(t *testing.T) {
+	memory := setup(t)
+
+	key, err := Save(fileHeader(t, "me.PNG", pngData(t, 120, 80)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidKey(key) {
+		t.Fatalf("invalid key %q", key)
+	}
+
+	for _, size := range config.AppConfig.AvatarSizes {
+		reader, obj, err := memory.Get(FileName(key, size))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		img, err := png.Decode(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("size %d: got %dx%d", size, b.Dx(), b.Dy())
+		}
+		if obj.ContentType != "image/png" {
+			t.Errorf("size %d: content type %q", size, obj.ContentType)
+		}
+	}
+
+	// 相同内容得到相同key
+	again, err := Save(fileHeader(t, "again.png", pngData(t, 120, 80)))
+	if err != nil || again != key {
+		t.Errorf("save again: key %q err %v, want %q", again, err, key)
+	}
+
+	if err = Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := memory.List(""); len(objects) != 0 {
+		t.Errorf("%d objects left after remove", len(objects))
+	}
+}
+
+func TestSaveReject(t *testing.T) {
+	memory := setup(t)
+
+	cases := []struct {
+		name     string
+		filename string
+		data     []byte
+		err      error
+	}{
+		{"ext", "me.bmp", pngData(t, 8, 8), ErrExtNotAllow},
+		{"type", "me.jpg", pngData(t, 8, 8), ErrTypeNotAllow},
+		{"not image", "me.png", []byte("\x89PNG\r\n\x1a\nnot a png"), ErrBadImage},
+		{"too large", "me.png", make([]byte, 64*1024+1), ErrTooLarge},
+		{"too many pixels", "me.png", hugePNG(), ErrBadImage},
+	}
+	for _, c := range cases {
+		if _, err := Save(fileHeader(t, c.filename, c.data)); err != c.err {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.err)
+		}
+	}
+
+	if objects, _ := memory.List(""); len(objects) != 0 {
+		t.Errorf("%d objects stored for rejected uploads", len(objects))
+	}
+}
@@ -23,13 +23,54 @@ type AppConf struct {
 
 	LogFilePath string `yaml:"LogFilePath"`
 
+	I18nPath          string `yaml:"I18nPath"`          // 消息目录, 每种语言一个 <locale>.yaml
+	I18nDefaultLocale string `yaml:"I18nDefaultLocale"` // 请求及用户都未指定语言时使用
+
+	StorageType        string `yaml:"StorageType"`
+	StoragePath        string `yaml:"StoragePath"`
+	StorageS3Endpoint  string `yaml:"StorageS3Endpoint"`
+	StorageS3AccessKey string `yaml:"StorageS3AccessKey"`
+	StorageS3SecretKey string `yaml:"StorageS3SecretKey"`
+	StorageS3Bucket    string `yaml:"StorageS3Bucket"`
+	StorageS3Region    string `yaml:"StorageS3Region"`
+	StorageS3UseSSL    bool   `yaml:"StorageS3UseSSL"`
+	FileMaxSize        int64  `yaml:"FileMaxSize"`
+
+	UploadTmpPath      string `yaml:"UploadTmpPath"`
+	UploadChunkMaxSize int64  `yaml:"UploadChunkMaxSize"`
+	UploadExpire       int    `yaml:"UploadExpire"`
+
+	AvatarPath    string `yaml:"AvatarPath"`
+	AvatarMaxSize int64  `yaml:"AvatarMaxSize"`
+	AvatarSizes   []int  `yaml:"AvatarSizes"`
+
 	DBType           string `yaml:"DBType"`
 	DBHost           string `yaml:"DBHost"`
 	DBUser           string `yaml:"DBUser"`
 	DBPassword       string `yaml:"DBPassword"`
 	DBDatabase       string `yaml:"DBDatabase"`
+	DBSSLMode        string `yaml:"DBSSLMode"`
+	DBSchema         string `yaml:"DBSchema"`
+	DBPath           string `yaml:"DBPath"`
+	DBMigrationPath  string `yaml:"DBMigrationPath"`
+	DBMigrateMode    string `yaml:"DBMigrateMode"`
+	SeedPath         string `yaml:"SeedPath"`
 	UserBasePassword string `yaml:"UserBasePassword"`
 	PageSize         uint   `yaml:"PageSize"`
+
+	UserDeletedNameReserved bool  `yaml:"UserDeletedNameReserved"` // 删除用户后是否保留其用户名
+	UserImportMaxSize       int64 `yaml:"UserImportMaxSize"`       // KB, 批量导入文件大小上限
+	UserImportMaxRows       int   `yaml:"UserImportMaxRows"`       // 批量导入行数上限
+
+	DBReplicaHosts []string `yaml:"DBReplicaHosts"` // 从库地址, 用户名/密码/库名与主库相同
+	DBStickyWindow int      `yaml:"DBStickyWindow"` // second, 客户端写操作后读请求使用主库的时长
+
+	DBMaxIdleConns    int `yaml:"DBMaxIdleConns"`
+	DBMaxOpenConns    int `yaml:"DBMaxOpenConns"`
+	DBConnMaxLifetime int `yaml:"DBConnMaxLifetime"` // second, 0为不限制
+	DBConnMaxIdleTime int `yaml:"DBConnMaxIdleTime"` // second, 0为不限制
+	DBConnectRetry    int `yaml:"DBConnectRetry"`    // 启动时连接失败的重试次数
+	DBPingInterval    int `yaml:"DBPingInterval"`    // second, 健康检查间隔
 }
 
 var AppConfig AppConf
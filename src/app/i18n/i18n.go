@@ -0,0 +1,167 @@
+package i18n
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"gopkg.in/yaml.v2"
+)
+
+// Params 消息中的参数, 消息中以 {name} 引用
+type Params map[string]interface{}
+
+// catalogs 各语言的消息, key 为消息标识, 如 error.not_found
+var catalogs = map[string]map[string]string{}
+
+var defaultLocale = "zh-CN"
+
+func InitI18n() {
+	logrus.Trace("init i18n")
+	err := Load(config.AppConfig.I18nPath, config.AppConfig.I18nDefaultLocale)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+	logrus.Trace("init i18n complate")
+}
+
+// Load 读取目录中的 <locale>.yaml, 如 zh-CN.yaml, 嵌套的key以.连接
+func Load(dir string, defaultLoc string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	loaded := map[string]map[string]string{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var data map[string]interface{}
+		if err = yaml.Unmarshal(content, &data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		catalog := map[string]string{}
+		flatten(catalog, "", data)
+		loaded[strings.TrimSuffix(filepath.Base(file), ".yaml")] = catalog
+	}
+	if _, ok := loaded[defaultLoc]; !ok {
+		return fmt.Errorf("i18n: default locale %q not found in %s", defaultLoc, dir)
+	}
+	catalogs = loaded
+	defaultLocale = defaultLoc
+	return nil
+}
+
+func flatten(catalog map[string]string, prefix string, data interface{}) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			flatten(catalog, prefix+key+".", value)
+		}
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			flatten(catalog, prefix+fmt.Sprint(key)+".", value)
+		}
+	case nil:
+	default:
+		catalog[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(v)
+	}
+}
+
+func DefaultLocale() string {
+	return defaultLocale
+}
+
+// Locales 已加载的语言
+func Locales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Supported 返回已加载的语言标识, 不区分大小写, 只有语言部分时匹配该语言的第一个地区, 如 en 匹配 en-US
+func Supported(locale string) (string, bool) {
+	locale = strings.Replace(strings.TrimSpace(locale), "_", "-", -1)
+	if locale == "" {
+		return "", false
+	}
+	locales := Locales()
+	for _, l := range locales {
+		if strings.EqualFold(l, locale) {
+			return l, true
+		}
+	}
+	lang := strings.SplitN(locale, "-", 2)[0]
+	for _, l := range locales {
+		if strings.EqualFold(strings.SplitN(l, "-", 2)[0], lang) {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// MatchAcceptLanguage 按 q 值从高到低匹配 Accept-Language, 没有支持的语言时返回空
+func MatchAcceptLanguage(header string) string {
+	type tag struct {
+		locale string
+		q      float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if locale := strings.TrimSpace(fields[0]); locale != "" && locale != "*" && q > 0 {
+			tags = append(tags, tag{locale, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	for _, t := range tags {
+		if locale, ok := Supported(t.locale); ok {
+			return locale
+		}
+	}
+	return ""
+}
+
+// T 翻译消息, 当前语言没有时使用默认语言, 都没有时 ok 为 false
+func T(locale string, key string, params Params) (string, bool) {
+	message, ok := catalogs[locale][key]
+	if !ok {
+		message, ok = catalogs[defaultLocale][key]
+	}
+	if !ok {
+		return "", false
+	}
+	return Format(message, params), true
+}
+
+// Format 替换消息中的 {name} 参数
+func Format(message string, params Params) string {
+	if len(params) == 0 || !strings.Contains(message, "{") {
+		return message
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(message)
+}
@@ -0,0 +1,348 @@
+// Package openapi 由注册的 gin 路由及接口说明生成 OpenAPI 3 文档
+// 请求/响应结构由 Go 类型反射生成, 字段约束取自 binding 标签
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+const Version = "3.0.3"
+
+// 接口的访问权限, 其他值为权限名, 如 manage_user
+const (
+	AccessPublic    = ""
+	AccessLogin     = "login"
+	AccessSuperuser = "superuser"
+)
+
+// Param 查询参数或请求头
+type Param struct {
+	Name        string
+	Type        string // 默认 string
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// Route 一个接口的说明, Method/Path 与注册的 gin 路由一致, 如 GET /api/v1/user/:id
+type Route struct {
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Description string
+	Access      string // 见 AccessXxx
+
+	PathTypes map[string]string   // 路径参数类型, 未指定时 id 类参数为 integer, 其他为 string
+	List      *models.QueryFields // 列表接口, 生成分页/搜索/过滤/排序/fields/include 参数
+	Query     []Param
+	Headers   []Param
+
+	Request  interface{} // JSON 请求体, 如 dto.UserCreate{}
+	FormFile string      // multipart 上传的文件字段名
+	RawBody  []string    // 请求体可为原始内容时的 Content-Type
+
+	Status      int         // 成功时的状态码, 默认200
+	Response    interface{} // 响应中 data 的类型, *Schema 时直接使用
+	ResponseArr bool        // data 为 Response 的数组
+	More        []Param     // 与 data 同级的其他字段, 见 ResponseJsonMore
+	Produces    []string    // 非 JSON 响应的 Content-Type, 如文件下载
+}
+
+func (r *Route) key() string {
+	return r.Method + " " + r.Path
+}
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Servers    []Server                         `json:"servers,omitempty"`
+	Tags       []Tag                            `json:"tags,omitempty"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Permission  string                `json:"x-permission,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Style       string  `json:"style,omitempty"`
+	Explode     bool    `json:"explode,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+var pathParamRegexp = regexp.MustCompile(`[:*](\w+)`)
+
+// 默认为 integer 的路径参数
+var idParams = map[string]bool{"id": true, "uid": true, "gid": true, "pid": true, "revision": true, "size": true}
+
+// Build 生成文档, 返回没有说明的路由及没有对应路由的说明
+func Build(info Info, rules Rules, routes gin.RoutesInfo, docs []Route) (*Document, []string) {
+	gen := newGenerator(rules)
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: gen.schemas,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"session": {Type: "apiKey", In: "cookie", Name: "session", Description: "登录 POST /api/v1/user/login 后返回的会话cookie"},
+			},
+		},
+	}
+	gen.envelopeSchemas()
+
+	registered := map[string]bool{}
+	for _, route := range routes {
+		registered[route.Method+" "+route.Path] = true
+	}
+	var problems []string
+	documented := map[string]bool{}
+	tags := map[string]bool{}
+	for i := range docs {
+		route := &docs[i]
+		if !registered[route.key()] {
+			problems = append(problems, "documented but not registered: "+route.key())
+			continue
+		}
+		documented[route.key()] = true
+		path := pathParamRegexp.ReplaceAllString(route.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*Operation{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = gen.operation(route)
+		if route.Tag != "" && !tags[route.Tag] {
+			tags[route.Tag] = true
+			doc.Tags = append(doc.Tags, Tag{Name: route.Tag})
+		}
+	}
+	for _, route := range routes {
+		if !documented[route.Method+" "+route.Path] {
+			problems = append(problems, "registered but not documented: "+route.Method+" "+route.Path)
+		}
+	}
+	sort.Strings(problems)
+	return doc, problems
+}
+
+func (g *generator) operation(route *Route) *Operation {
+	op := &Operation{
+		Summary:     route.Summary,
+		Description: route.Description,
+		OperationID: operationID(route),
+		Responses:   map[string]*Response{},
+	}
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+
+	for _, match := range pathParamRegexp.FindAllStringSubmatch(route.Path, -1) {
+		name := match[1]
+		typ := route.PathTypes[name]
+		if typ == "" {
+			typ = "string"
+			if idParams[name] {
+				typ = "integer"
+			}
+		}
+		op.Parameters = append(op.Parameters, &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: typ}})
+	}
+	if route.List != nil {
+		op.Parameters = append(op.Parameters, listParams(route.List)...)
+	}
+	for _, p := range route.Query {
+		op.Parameters = append(op.Parameters, p.parameter("query"))
+	}
+	for _, p := range route.Headers {
+		op.Parameters = append(op.Parameters, p.parameter("header"))
+	}
+
+	switch {
+	case route.Request != nil:
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{
+			"application/json": {Schema: g.schema(route.Request)},
+		}}
+	case route.FormFile != "":
+		form := &Schema{Type: "object", Required: []string{route.FormFile}, Properties: map[string]*Schema{
+			route.FormFile: {Type: "string", Format: "binary"},
+		}}
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{"multipart/form-data": {Schema: form}}}
+	}
+	for _, contentType := range route.RawBody {
+		if op.RequestBody == nil {
+			op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{}}
+		}
+		op.RequestBody.Content[contentType] = &MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	switch {
+	case status == http.StatusNoContent:
+	case len(route.Produces) != 0:
+		success.Content = map[string]*MediaType{}
+		for _, contentType := range route.Produces {
+			success.Content[contentType] = &MediaType{Schema: &Schema{Type: "string", Format: "binary"}}
+		}
+	default:
+		success.Content = map[string]*MediaType{"application/json": {Schema: g.envelope(route)}}
+	}
+	op.Responses[fmt.Sprint(status)] = success
+
+	errorResponse := func(status int) {
+		op.Responses[fmt.Sprint(status)] = &Response{Description: http.StatusText(status), Content: errorContent()}
+	}
+	switch route.Access {
+	case AccessPublic:
+	case AccessLogin:
+		op.Security = []map[string][]string{{"session": {}}}
+		errorResponse(http.StatusUnauthorized)
+	default:
+		op.Security = []map[string][]string{{"session": {}}}
+		op.Permission = route.Access
+		errorResponse(http.StatusUnauthorized)
+		errorResponse(http.StatusForbidden)
+	}
+	if route.Request != nil {
+		errorResponse(http.StatusUnprocessableEntity)
+	}
+	for _, header := range route.Headers {
+		if header.Name == "If-Match" {
+			errorResponse(http.StatusPreconditionFailed)
+		}
+	}
+	op.Responses["default"] = &Response{Description: "Error", Content: errorContent()}
+	return op
+}
+
+func errorContent() map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: ref("Error")}}
+}
+
+func (p Param) parameter(in string) *Parameter {
+	schema := &Schema{Type: p.Type}
+	if schema.Type == "" {
+		schema.Type = "string"
+	}
+	for _, v := range p.Enum {
+		schema.Enum = append(schema.Enum, v)
+	}
+	return &Parameter{Name: p.Name, In: in, Description: p.Description, Required: p.Required, Schema: schema}
+}
+
+// listParams 列表接口的参数, 说明见 ResponseList 及 QueryFields.Parse
+func listParams(fields *models.QueryFields) []*Parameter {
+	params := []*Parameter{
+		{Name: "page", In: "query", Description: "页码分页, 从1开始", Schema: &Schema{Type: "integer"}},
+		{Name: "pagesize", In: "query", Description: "每页数量", Schema: &Schema{Type: "integer"}},
+		{Name: "cursor", In: "query", Description: "游标分页, 首页为空, 下一页使用返回的 next_cursor", Schema: &Schema{Type: "string"}},
+		{Name: "count", In: "query", Description: "游标分页时是否返回总数", Schema: &Schema{Type: "boolean"}},
+	}
+	if len(fields.Search) != 0 {
+		params = append(params, &Parameter{Name: "q", In: "query",
+			Description: "在 " + strings.Join(fields.Search, ", ") + " 中模糊搜索", Schema: &Schema{Type: "string"}})
+	}
+	if len(fields.Sort) != 0 {
+		description := "排序字段, 逗号分隔, - 表示倒序, 可选: " + strings.Join(fields.Sort, ", ")
+		if fields.DefaultSort != "" {
+			description += ", 默认 " + fields.DefaultSort
+		}
+		params = append(params, &Parameter{Name: "sort", In: "query", Description: description, Schema: &Schema{Type: "string"}})
+	}
+	if len(fields.Filter) != 0 {
+		params = append(params, &Parameter{Name: "filter", In: "query", Style: "deepObject", Explode: true,
+			Description: "过滤, 如 filter[id]=1 或 id[gte]=1, 操作符: eq/ne/gt/gte/lt/lte/like/in, 可选字段: " + strings.Join(fields.Filter, ", "),
+			Schema:      &Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}})
+	}
+	if len(fields.Select) != 0 {
+		params = append(params, &Parameter{Name: "fields", In: "query",
+			Description: "只返回所选字段, 逗号分隔, 可选: " + strings.Join(fields.Select, ", "), Schema: &Schema{Type: "string"}})
+	}
+	if len(fields.Include) != 0 {
+		var includes []string
+		for name := range fields.Include {
+			includes = append(includes, name)
+		}
+		sort.Strings(includes)
+		params = append(params, &Parameter{Name: "include", In: "query",
+			Description: "同时返回的关联, 逗号分隔, 可选: " + strings.Join(includes, ", "), Schema: &Schema{Type: "string"}})
+	}
+	return params
+}
+
+// operationID 如 GET /api/v1/user/:id/history -> getUserIdHistory
+func operationID(route *Route) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.Split(strings.TrimPrefix(route.Path, "/api/v1"), "/") {
+		part = strings.TrimLeft(part, ":*")
+		for _, word := range strings.Split(part, "_") {
+			if word != "" {
+				id += strings.ToUpper(word[:1]) + word[1:]
+			}
+		}
+	}
+	return id
+}
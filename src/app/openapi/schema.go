@@ -0,0 +1,285 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+)
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// Rules binding 标签中自定义规则对应的约束, 如 username 规则设置 Pattern
+type Rules map[string]func(schema *Schema)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// generator 结构体生成到 components.schemas 中, 以类型名引用
+type generator struct {
+	rules   Rules
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newGenerator(rules Rules) *generator {
+	return &generator{rules: rules, schemas: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+// envelopeSchemas ResponseJson 及错误响应的外层结构
+func (g *generator) envelopeSchemas() {
+	g.schemas["Response"] = &Schema{Type: "object", Required: []string{"code", "message", "message_key"}, Properties: map[string]*Schema{
+		"code":        {Type: "integer", Description: "HTTP状态码"},
+		"message":     {Type: "string", Description: "按请求语言翻译的消息"},
+		"message_key": {Type: "string", Description: "消息标识, 如 status.200"},
+	}}
+	g.schemas["Error"] = &Schema{AllOf: []*Schema{ref("Response"), {Type: "object", Properties: map[string]*Schema{
+		"error_code": {Type: "string", Description: "稳定的错误码, 如 not_found/validation_failed"},
+		"request_id": {Type: "string"},
+		"params":     {Type: "object", Description: "消息参数"},
+		"details":    {Type: "array", Description: "字段错误", Items: g.typeSchema(reflect.TypeOf(apperr.FieldError{}))},
+		"data":       {Description: "版本冲突时为记录的当前数据, 整体导入失败时为导入结果"},
+	}}}}
+}
+
+// envelope 成功响应, data 为 Route.Response
+func (g *generator) envelope(route *Route) *Schema {
+	var data *Schema
+	if route.Response != nil {
+		data = g.schema(route.Response)
+		if route.ResponseArr || route.List != nil {
+			data = &Schema{Type: "array", Items: data}
+		}
+	} else {
+		data = &Schema{Nullable: true}
+	}
+	body := &Schema{Type: "object", Properties: map[string]*Schema{"data": data}}
+	if route.List != nil {
+		body.Properties["count"] = &Schema{Type: "integer", Description: "总数, 游标分页时需 count=true"}
+		body.Properties["next_cursor"] = &Schema{Type: "string", Description: "游标分页的下一页"}
+		body.Properties["prev_cursor"] = &Schema{Type: "string", Description: "游标分页的上一页"}
+	}
+	for _, p := range route.More {
+		body.Properties[p.Name] = p.parameter("").Schema
+		body.Properties[p.Name].Description = p.Description
+	}
+	return &Schema{AllOf: []*Schema{ref("Response"), body}}
+}
+
+func (g *generator) schema(v interface{}) *Schema {
+	if s, ok := v.(*Schema); ok {
+		return s
+	}
+	return g.typeSchema(reflect.TypeOf(v))
+}
+
+func (g *generator) typeSchema(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t, nullable = t.Elem(), true
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time", Nullable: nullable}
+	}
+	// 自定义 JSON 格式的类型无法反射, 作为任意值
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return &Schema{Nullable: nullable}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean", Nullable: nullable}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32", Nullable: nullable}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64", Nullable: nullable}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Minimum: &zero, Nullable: nullable}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Nullable: nullable}
+	case reflect.String:
+		return &Schema{Type: "string", Nullable: nullable}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: nullable}
+		}
+		// nil 切片输出为 null
+		return &Schema{Type: "array", Items: g.typeSchema(t.Elem()), Nullable: true}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.typeSchema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return ref(g.define(t))
+	}
+	return &Schema{}
+}
+
+// define 生成结构体的 schema, 返回名称; 先登记名称, 支持相互引用的结构体
+func (g *generator) define(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, exists := g.schemas[name]; exists {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.names[t] = name
+	g.schemas[name] = &Schema{}
+	*g.schemas[name] = *g.structSchema(t)
+	return name
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.fields(s, t)
+	return s
+}
+
+// fields 按 encoding/json 的规则输出字段, 嵌入的结构体字段展开
+func (g *generator) fields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(s, ft)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fs := g.typeSchema(field.Type)
+		required := false
+		if fs.Ref == "" {
+			required = g.binding(fs, field.Tag.Get("binding"))
+		}
+		if description := field.Tag.Get("description"); description != "" {
+			if fs.Ref != "" {
+				fs = &Schema{AllOf: []*Schema{fs}}
+			}
+			fs.Description = description
+		}
+		s.Properties[name] = fs
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// binding 将校验规则转换为约束, 返回是否必填
+// dive 之后的规则作用于数组元素, 含 | 的组合规则不转换
+func (g *generator) binding(s *Schema, tag string) bool {
+	required := false
+	target := s
+	for _, rule := range strings.Split(tag, ",") {
+		if rule == "" || strings.Contains(rule, "|") {
+			continue
+		}
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "required":
+			required = required || target == s
+		case "min", "max", "len":
+			limit(target, name, param)
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				target.Enum = append(target.Enum, v)
+			}
+		case "email":
+			target.Format = "email"
+		case "hexadecimal":
+			target.Pattern = "^(0[xX])?[0-9a-fA-F]+$"
+		case "numeric":
+			target.Pattern = `^[-+]?[0-9]+(\.[0-9]+)?$`
+		default:
+			if fn, ok := g.rules[name]; ok {
+				fn(target)
+			}
+		}
+	}
+	return required
+}
+
+// limit min/max/len 对字符串为长度, 对数组为元素数量, 对数字为取值范围
+func limit(s *Schema, name string, param string) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	i := int(n)
+	switch s.Type {
+	case "string":
+		if name != "max" {
+			s.MinLength = &i
+		}
+		if name != "min" {
+			s.MaxLength = &i
+		}
+	case "array":
+		if name != "max" {
+			s.MinItems = &i
+		}
+		if name != "min" {
+			s.MaxItems = &i
+		}
+	case "integer", "number":
+		if name != "max" {
+			s.Minimum = &n
+		}
+		if name != "min" {
+			s.Maximum = &n
+		}
+	}
+}
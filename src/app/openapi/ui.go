@@ -0,0 +1,24 @@
+package openapi
+
+import "strings"
+
+// redocHTML 文档页面, 页面内置在程序中, Redoc 脚本从 CDN 加载
+const redocHTML = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{title}</title>
+  <style>body { margin: 0; padding: 0; }</style>
+</head>
+<body>
+  <redoc spec-url="{spec}"></redoc>
+  <script src="https://cdn.jsdelivr.net/npm/redoc@2/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+// UIPage 显示 specURL 文档的 Redoc 页面
+func UIPage(title string, specURL string) string {
+	return strings.NewReplacer("{title}", title, "{spec}", specURL).Replace(redocHTML)
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+// Local 本地文件系统存储
+type Local struct {
+	Dir       string
+	URLPrefix string // SignedURL 的下载地址
+	secret    []byte
+}
+
+func NewLocal(dir string, urlPrefix string, secret []byte) (*Local, error) {
+	if err := utils.IsNotExistMkDir(dir); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir, URLPrefix: urlPrefix, secret: secret}, nil
+}
+
+func (s *Local) fullPath(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+func (s *Local) Put(key string, reader io.Reader, size int64, contentType string) error {
+	fullPath, err := s.fullPath(key)
+	if err != nil {
+		return err
+	}
+	if err = utils.MkDir(filepath.Dir(fullPath)); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名, 避免读到写了一半的文件
+	tmp := fullPath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fullPath)
+}
+
+func (s *Local) Get(key string) (io.ReadCloser, *Object, error) {
+	fullPath, err := s.fullPath(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(fullPath)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, s.object(key, info), nil
+}
+
+func (s *Local) Delete(key string) error {
+	fullPath, err := s.fullPath(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Local) Stat(key string) (*Object, error) {
+	fullPath, err := s.fullPath(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.object(key, info), nil
+}
+
+func (s *Local) List(prefix string) ([]*Object, error) {
+	var objects []*Object
+	err := filepath.Walk(s.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) == ".tmp" {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, s.object(key, info))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return objects, err
+}
+
+func (s *Local) SignedURL(key string, expires time.Duration) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	expireAt := time.Now().Add(expires).Unix()
+	query := url.Values{}
+	query.Set("key", key)
+	query.Set("expires", strconv.FormatInt(expireAt, 10))
+	query.Set("sign", s.sign(key, expireAt))
+	return fmt.Sprintf("%s?%s", s.URLPrefix, query.Encode()), nil
+}
+
+// VerifySignedURL 校验 SignedURL 生成的签名和有效期
+func (s *Local) VerifySignedURL(key string, expires string, sign string) bool {
+	expireAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expireAt {
+		return false
+	}
+	return hmac.Equal([]byte(sign), []byte(s.sign(key, expireAt)))
+}
+
+func (s *Local) sign(key string, expireAt int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%d", key, expireAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Local) object(key string, info os.FileInfo) *Object {
+	return &Object{
+		Key:         key,
+		Size:        info.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     info.ModTime(),
+	}
+}
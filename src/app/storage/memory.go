@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory 内存存储, 用于测试
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data []byte
+	info Object
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: map[string]*memoryObject{}}
+}
+
+func (s *Memory) Put(key string, reader io.Reader, size int64, contentType string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &memoryObject{
+		data: data,
+		info: Object{Key: key, Size: int64(len(data)), ContentType: contentType, ModTime: time.Now()},
+	}
+	return nil
+}
+
+func (s *Memory) Get(key string) (io.ReadCloser, *Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	info := obj.info
+	return readSeekNopCloser{bytes.NewReader(obj.data)}, &info, nil
+}
+
+func (s *Memory) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *Memory) Stat(key string) (*Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := obj.info
+	return &info, nil
+}
+
+func (s *Memory) List(prefix string) ([]*Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var objects []*Object
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			info := obj.info
+			objects = append(objects, &info)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *Memory) SignedURL(key string, expires time.Duration) (string, error) {
+	return "", ErrUnsupported
+}
+
+// readSeekNopCloser 保留 Seek 方便 http.ServeContent 使用
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
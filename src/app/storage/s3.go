@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+)
+
+type S3Options struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	Prefix    string // 所有对象key的前缀
+}
+
+// S3 兼容 S3 协议的对象存储, 如 AWS S3 / MinIO
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	client, err := minio.NewWithRegion(opts.Endpoint, opts.AccessKey, opts.SecretKey, opts.UseSSL, opts.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(opts.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err = client.MakeBucket(opts.Bucket, opts.Region); err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3{client: client, bucket: opts.Bucket, prefix: strings.Trim(opts.Prefix, "/")}, nil
+}
+
+func (s *S3) objectName(key string) (string, error) {
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(s.prefix, key), nil
+}
+
+func (s *S3) object(info minio.ObjectInfo) *Object {
+	key := strings.TrimPrefix(info.Key, s.prefix)
+	return &Object{
+		Key:         strings.TrimPrefix(key, "/"),
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		ModTime:     info.LastModified,
+	}
+}
+
+func (s *S3) Put(key string, reader io.Reader, size int64, contentType string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(s.bucket, name, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, *Object, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := s.client.GetObject(s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, toStorageError(err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, toStorageError(err)
+	}
+	return obj, s.object(info), nil
+}
+
+func (s *S3) Delete(key string) error {
+	name, err := s.objectName(key)
+	if err != nil {
+		return err
+	}
+	return toStorageError(s.client.RemoveObject(s.bucket, name))
+}
+
+func (s *S3) Stat(key string) (*Object, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.client.StatObject(s.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, toStorageError(err)
+	}
+	return s.object(info), nil
+}
+
+func (s *S3) List(prefix string) ([]*Object, error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	if s.prefix != "" {
+		prefix = s.prefix + "/" + prefix
+	}
+	var objects []*Object
+	for info := range s.client.ListObjectsV2(s.bucket, prefix, true, doneCh) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, s.object(info))
+	}
+	return objects, nil
+}
+
+func (s *S3) SignedURL(key string, expires time.Duration) (string, error) {
+	name, err := s.objectName(key)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.client.PresignedGetObject(s.bucket, name, expires, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func toStorageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
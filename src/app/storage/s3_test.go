@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 按 path-style 处理 bucket 及对象的增删查, 不校验签名
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*memoryObject
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential=test-ak/") && r.URL.Query().Get("X-Amz-Credential") == "" {
+		f.error(w, r, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, name := parts[0], ""
+	if len(parts) == 2 {
+		name = parts[1]
+	}
+	objects, exists := f.buckets[bucket]
+
+	if name == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !exists {
+				f.error(w, r, http.StatusNotFound, "NoSuchBucket")
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = map[string]*memoryObject{}
+		default:
+			f.error(w, r, http.StatusNotImplemented, "NotImplemented")
+		}
+		return
+	}
+	if !exists {
+		f.error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := readPayload(r)
+		if err != nil {
+			f.error(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		objects[name] = &memoryObject{
+			data: data,
+			info: Object{Key: name, Size: int64(len(data)), ContentType: r.Header.Get("Content-Type"), ModTime: time.Now()},
+		}
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		obj, ok := objects[name]
+		if !ok {
+			f.error(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		w.Header().Set("Content-Type", obj.info.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.info.ModTime.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// readPayload 解析 aws-chunked 格式: <hex长度>;chunk-signature=xxx\r\n<数据>\r\n, 长度为0时结束
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+	var data []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func newTestS3(t *testing.T) (*S3, *fakeS3) {
+	fake := &fakeS3{buckets: map[string]map[string]*memoryObject{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3(S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "test-ak",
+		SecretKey: "test-sk",
+		Bucket:    "avatars",
+		Region:    "us-east-1",
+		Prefix:    "/base/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+func TestS3(t *testing.T) {
+	s, fake := newTestS3(t)
+	if _, ok := fake.buckets["avatars"]; !ok {
+		t.Fatal("bucket not created")
+	}
+
+	content := "hello storage"
+	if err := s.Put("files/a.txt", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.buckets["avatars"]["base/files/a.txt"]; !ok {
+		t.Fatal("object not stored under prefix")
+	}
+
+	reader, obj, err := s.Get("files/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != content {
+		t.Fatalf("get: %q %v", data, err)
+	}
+	if obj.Key != "files/a.txt" || obj.Size != int64(len(content)) || obj.ContentType != "text/plain" {
+		t.Errorf("get object info: %+v", obj)
+	}
+
+	signed, err := s.SignedURL("files/a.txt", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Path != "/avatars/base/files/a.txt" || query.Get("X-Amz-Expires") != "60" || query.Get("X-Amz-Signature") == "" {
+		t.Errorf("signed url: %s", signed)
+	}
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != content {
+		t.Errorf("download signed url: %d %q", resp.StatusCode, data)
+	}
+
+	if err = s.Delete("files/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Get("files/a.txt"); err != ErrNotFound {
+		t.Errorf("get deleted: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err = s.Stat("files/a.txt"); err != ErrNotFound {
+		t.Errorf("stat deleted: got %v, want %v", err, ErrNotFound)
+	}
+
+	if err = s.Put("../a.txt", strings.NewReader(content), int64(len(content)), "text/plain"); err != ErrInvalidKey {
+		t.Errorf("put invalid key: got %v, want %v", err, ErrInvalidKey)
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+var (
+	ErrNotFound    = errors.New("object not exist")
+	ErrInvalidKey  = errors.New("object key invalid")
+	ErrUnsupported = errors.New("storage operation unsupported")
+)
+
+// Object 存储对象信息
+type Object struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// Storage 文件存储后端
+// key 为 / 分隔的相对路径, 如 files/2020/09/xxx
+type Storage interface {
+	// Put size 未知时传 -1
+	Put(key string, reader io.Reader, size int64, contentType string) error
+	// Get 调用方负责关闭返回的 ReadCloser
+	Get(key string) (io.ReadCloser, *Object, error)
+	Delete(key string) error
+	Stat(key string) (*Object, error)
+	List(prefix string) ([]*Object, error)
+	// SignedURL 生成有效期内无需登录即可下载的地址
+	SignedURL(key string, expires time.Duration) (string, error)
+}
+
+var defaultStorage Storage
+
+func InitStorage() {
+	logrus.Trace("init storage")
+	var err error
+	defaultStorage, err = New(config.AppConfig.StorageType, config.AppConfig.StoragePath)
+	if err != nil {
+		logrus.Panicln(err)
+	}
+	logrus.Trace("init storage complate")
+}
+
+// New 根据配置创建存储后端, local 后端的文件保存在 localPath 下
+func New(storageType string, localPath string) (Storage, error) {
+	switch storageType {
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:  config.AppConfig.StorageS3Endpoint,
+			AccessKey: config.AppConfig.StorageS3AccessKey,
+			SecretKey: config.AppConfig.StorageS3SecretKey,
+			Bucket:    config.AppConfig.StorageS3Bucket,
+			Region:    config.AppConfig.StorageS3Region,
+			UseSSL:    config.AppConfig.StorageS3UseSSL,
+			Prefix:    localPath,
+		})
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return NewLocal(localPath, "/api/v1/storage", []byte(config.AppConfig.AppSecret))
+	}
+}
+
+func GetStorage() Storage {
+	return defaultStorage
+}
+
+// SetStorage 替换默认存储后端, 如测试时使用 Memory
+func SetStorage(s Storage) {
+	defaultStorage = s
+}
+
+// NewKey 生成按日期分目录的随机key, 如 files/2020/09/01/xxx
+func NewKey(prefix string) (string, error) {
+	random, err := utils.RandomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(prefix, time.Now().Format("2006/01/02"), random), nil
+}
+
+// CheckKey 禁止绝对路径和 .. 防止越出存储目录
+func CheckKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	if path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return ErrInvalidKey
+	}
+	return nil
+}
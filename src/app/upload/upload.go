@@ -0,0 +1,241 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+var (
+	ErrTooLarge       = errors.New("upload file too large")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrChunkTooLarge  = errors.New("upload chunk too large")
+	ErrChecksum       = errors.New("upload checksum mismatch")
+	ErrExpired        = errors.New("upload expired")
+	ErrCompleted      = errors.New("upload already completed")
+)
+
+// 同一个上传任务的分片必须串行写入
+var locks sync.Map
+
+func lock(id string) func() {
+	value, _ := locks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func InitUpload() {
+	logrus.Trace("init upload")
+	err := utils.MkDir(config.AppConfig.UploadTmpPath)
+	if err != nil {
+		logrus.Panicln(err)
+	}
+	// cleanup abandoned uploads every hour
+	go periodicCleanup(1 * time.Hour)
+	logrus.Trace("init upload complate")
+}
+
+func tmpPath(id string) string {
+	return path.Join(config.AppConfig.UploadTmpPath, id)
+}
+
+// Create 创建上传任务, hash 为整个文件的sha256, 为空时不校验
+func Create(ownerID uint, name string, size int64, hash string) (*models.Upload, error) {
+	if size > config.AppConfig.FileMaxSize*1024 {
+		return nil, ErrTooLarge
+	}
+
+	id, err := utils.RandomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(tmpPath(id))
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	task := &models.Upload{
+		ID:        id,
+		OwnerID:   ownerID,
+		Name:      name,
+		Size:      size,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.UploadExpire) * time.Hour),
+	}
+	if err = task.Create(); err != nil {
+		_ = os.Remove(tmpPath(id))
+		return nil, err
+	}
+	return task, nil
+}
+
+// WriteChunk 从 offset 开始流式写入一个分片, checksum 为该分片的sha256, 为空时不校验
+// 写满后自动合并存储并生成 File
+func WriteChunk(task *models.Upload, offset int64, body io.Reader, checksum string) error {
+	unlock := lock(task.ID)
+	defer unlock()
+
+	// 加锁后重新读取, 获取最新进度
+	if err := models.Detail(task); err != nil {
+		return err
+	}
+	if task.FileID != 0 {
+		return ErrCompleted
+	}
+	if time.Now().After(task.ExpiresAt) {
+		return ErrExpired
+	}
+	if offset != task.Offset {
+		return ErrOffsetMismatch
+	}
+
+	limit := task.Size - task.Offset
+	if chunkMax := config.AppConfig.UploadChunkMaxSize * 1024; limit > chunkMax {
+		limit = chunkMax
+	}
+
+	f, err := os.OpenFile(tmpPath(task.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Seek(task.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(io.LimitReader(body, limit), hasher))
+	if err == nil {
+		if extra, _ := io.ReadFull(body, make([]byte, 1)); extra > 0 {
+			err = ErrChunkTooLarge
+		}
+	}
+	if err == nil && checksum != "" && hex.EncodeToString(hasher.Sum(nil)) != checksum {
+		err = ErrChecksum
+	}
+	if err != nil {
+		// 丢弃本次写入, 客户端从原 offset 重传
+		_ = f.Truncate(task.Offset)
+		return err
+	}
+
+	err = task.UpdateColumns(map[string]interface{}{"received": task.Offset + n})
+	if err != nil {
+		_ = f.Truncate(task.Offset)
+		return err
+	}
+	task.Offset += n
+
+	if task.Offset == task.Size {
+		return finish(task)
+	}
+	return nil
+}
+
+// finish 校验整个文件并存入 storage
+func finish(task *models.Upload) error {
+	f, err := os.Open(tmpPath(task.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, f); err != nil {
+		return err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if task.Hash != "" && hash != task.Hash {
+		// 整个文件损坏, 只能重新上传
+		_ = task.UpdateColumns(map[string]interface{}{"received": 0})
+		task.Offset = 0
+		_ = os.Truncate(tmpPath(task.ID), 0)
+		return ErrChecksum
+	}
+
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	storageKey, err := storage.NewKey("files")
+	if err != nil {
+		return err
+	}
+	file := models.File{
+		OwnerID:    task.OwnerID,
+		Name:       task.Name,
+		StorageKey: storageKey,
+		Size:       task.Size,
+		Hash:       hash,
+		MimeType:   http.DetectContentType(head[:n]),
+	}
+	err = storage.GetStorage().Put(file.StorageKey, f, file.Size, file.MimeType)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err = file.Create(); err != nil {
+		_ = storage.GetStorage().Delete(file.StorageKey)
+		return err
+	}
+
+	if err = task.UpdateColumns(map[string]interface{}{"file_id": file.ID}); err != nil {
+		return err
+	}
+	task.FileID = file.ID
+	_ = os.Remove(tmpPath(task.ID))
+	return nil
+}
+
+// Abort 取消上传任务
+func Abort(task *models.Upload) error {
+	unlock := lock(task.ID)
+	defer unlock()
+
+	err := os.Remove(tmpPath(task.ID))
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Error(err)
+	}
+	locks.Delete(task.ID)
+	return task.Delete()
+}
+
+func periodicCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cleanup()
+	}
+}
+
+// cleanup 删除过期的上传任务和未完成的临时文件
+func cleanup() {
+	tasks, err := models.ExpiredUploads()
+	if err != nil {
+		return
+	}
+	for _, task := range tasks {
+		if err = Abort(task); err != nil {
+			logrus.Error(err)
+		}
+	}
+	if len(tasks) > 0 {
+		logrus.Info("cleanup expired uploads: ", len(tasks))
+	}
+}
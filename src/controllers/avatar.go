@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/avatar"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// 上传头像
+func UserAvatarPost(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+
+	if err := models.LoadColumns(&user, []string{"id", "avatar"}); err != nil {
+		ResponseError(c, apperr.ErrNotFound.WithCause(err))
+		return
+	}
+
+	fileHeader, ok := formFile(c, "avatar", config.AppConfig.AvatarMaxSize*1024)
+	if !ok {
+		return
+	}
+
+	// 文件过大/类型不支持等错误由 ErrorMiddleware 转换为对应的错误码
+	key, err := avatar.Save(fileHeader)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+
+	oldKey := user.Avatar
+	if err = user.SetAvatarTx(GetRequestDB(c), key); err != nil {
+		ResponseError(c, err)
+		return
+	}
+	if oldKey != "" && oldKey != key && !models.AvatarInUse(oldKey) {
+		_ = avatar.Remove(oldKey)
+	}
+
+	ResponseJson(c, http.StatusOK, map[string]interface{}{"avatar": key})
+}
+
+// 删除头像
+func UserAvatarDelete(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+
+	if err := models.LoadColumns(&user, []string{"id", "avatar"}); err != nil {
+		ResponseError(c, apperr.ErrNotFound.WithCause(err))
+		return
+	}
+
+	oldKey := user.Avatar
+	if err := user.SetAvatarTx(GetRequestDB(c), ""); err != nil {
+		ResponseError(c, err)
+		return
+	}
+	if oldKey != "" && !models.AvatarInUse(oldKey) {
+		_ = avatar.Remove(oldKey)
+	}
+	ResponseJson(c, http.StatusNoContent, nil)
+}
+
+// 获取头像图片
+// 文件名由内容hash决定, 内容不会变化, 可让客户端长期缓存
+func AvatarGet(c *gin.Context) {
+	key := c.Param("key")
+	size, err := strconv.Atoi(c.Param("size"))
+	if err != nil || !avatar.ValidKey(key) || !avatar.ValidSize(size) {
+		ResponseError(c, apperr.ErrNotFound)
+		return
+	}
+
+	fileName := avatar.FileName(key, size)
+	content, obj, err := avatar.GetStore().Get(fileName)
+	if err == storage.ErrNotFound {
+		ResponseError(c, apperr.ErrNotFound)
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ResponseError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "image/png")
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", `"`+fileName+`"`)
+	defer content.Close()
+	if rs, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", obj.ModTime, rs)
+		return
+	}
+	c.DataFromReader(http.StatusOK, obj.Size, "image/png", content, nil)
+}
@@ -1,35 +1,38 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/dto"
+	"github.com/sulin2018/go-web-base/src/middleware"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/presenter"
+	"github.com/sulin2018/go-web-base/src/utils"
 )
 
-var statusMessage = map[int]string{
-	http.StatusBadRequest:       "参数有误",
-	http.StatusUnauthorized:     "缺少认证信息",
-	http.StatusForbidden:        "无权限",
-	http.StatusMethodNotAllowed: "服务器未实现的请求方法",
-
-	http.StatusInternalServerError: "服务器出错",
-	http.StatusNotImplemented:      "服务器未实现",
-}
-
 func ResponseJson(c *gin.Context, httpCode int, data interface{}) {
-	var result = map[string]interface{}{
-		"code":    httpCode,
-		"message": GetStatusMsg(httpCode),
-		"data":    data,
-	}
-	c.JSON(httpCode, result)
+	ResponseJsonMore(c, httpCode, data, nil)
 }
 
+// message 按请求的语言翻译, message_key 为消息标识
 func ResponseJsonMore(c *gin.Context, httpCode int, data interface{}, moreInfo map[string]interface{}) {
 	var result = map[string]interface{}{
-		"code":    httpCode,
-		"message": GetStatusMsg(httpCode),
-		"data":    data,
+		"code":        httpCode,
+		"message":     GetStatusMsg(c, httpCode),
+		"message_key": statusKey(httpCode),
+		"data":        data,
 	}
 	for k, v := range moreInfo {
 		result[k] = v
@@ -37,14 +40,278 @@ func ResponseJsonMore(c *gin.Context, httpCode int, data interface{}, moreInfo m
 	c.JSON(httpCode, result)
 }
 
-func GetStatusMsg(code int) string {
-	msg, ok := statusMessage[code]
-	if ok {
-		return msg
+// ResponseError 记录错误并中止, 由 middleware.ErrorMiddleware 转换为 AppError 统一输出
+// 数据库等内部错误只返回错误码, 原始信息记录到日志
+func ResponseError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// invalidParam 请求参数绑定失败, 请求体格式错误时为 400
+// 校验失败的字段及类型不符的字段为 422, 列在 details 中
+func invalidParam(err error) *apperr.AppError {
+	var details []apperr.FieldError
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validationErrs):
+		details = dto.FieldErrors(validationErrs)
+	case errors.As(err, &typeErr):
+		details = append(details, apperr.FieldError{Field: typeErr.Field, Code: "type", Message: "should be " + typeErr.Type.String(),
+			Params: map[string]interface{}{"type": typeErr.Type.String()}})
+	default:
+		return apperr.ErrInvalidParam.WithCause(err)
+	}
+	return apperr.ErrValidation.WithDetails(details...).WithCause(err)
+}
+
+// formFile 限制请求体大小后读取上传的文件, 失败时已写入响应
+// 超出大小时不会把整个请求写入临时文件, 返回 ErrTooLarge
+func formFile(c *gin.Context, name string, maxSize int64) (*multipart.FileHeader, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+	fileHeader, err := c.FormFile(name)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		ResponseError(c, apperr.ErrTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		logrus.Error(err)
+		ResponseError(c, invalidParam(err))
+		return nil, false
+	}
+	if fileHeader.Size > maxSize {
+		ResponseError(c, apperr.ErrTooLarge)
+		return nil, false
+	}
+	return fileHeader, true
+}
+
+// bindRequest 绑定并校验请求体, 失败时已写入响应
+// 请求体为 dto.Target 时同时检查 unique 字段, id 为当前记录, 新增时为0; 所有字段的错误一起以 422 返回
+func bindRequest(c *gin.Context, req interface{}, id uint) bool {
+	var details []apperr.FieldError
+	if err := c.ShouldBindJSON(req); err != nil {
+		var validationErrs validator.ValidationErrors
+		if !errors.As(err, &validationErrs) {
+			ResponseError(c, invalidParam(err))
+			return false
+		}
+		details = invalidParam(err).Details
+	}
+
+	if target, ok := req.(dto.Target); ok {
+		skip := map[string]bool{}
+		for _, detail := range details {
+			skip[detail.Field] = true
+		}
+		unique, err := dto.CheckUnique(GetRequestDB(c), target, id, skip)
+		if err != nil {
+			ResponseError(c, err)
+			return false
+		}
+		details = append(details, unique...)
+	}
+
+	if len(details) != 0 {
+		ResponseError(c, apperr.ErrValidation.WithDetails(details...))
+		return false
+	}
+	return true
+}
+
+func statusKey(code int) string {
+	return "status." + strconv.Itoa(code)
+}
+
+// GetStatusMsg 状态码对应的提示, 消息目录中没有时为标准的状态描述
+func GetStatusMsg(c *gin.Context, code int) string {
+	return middleware.T(c, statusKey(code), nil, http.StatusText(code))
+}
+
+// SetETag 以记录版本作为ETag, 客户端修改时通过 If-Match 带回
+func SetETag(c *gin.Context, version uint) {
+	c.Header("ETag", fmt.Sprintf(`"%d"`, version))
+}
+
+// IfMatchVersion 解析 If-Match 中的版本, 失败时已写入响应
+func IfMatchVersion(c *gin.Context) (uint, bool) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		ResponseError(c, apperr.ErrVersionRequired)
+		return 0, false
+	}
+
+	version, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+	if err != nil || version == 0 {
+		ResponseError(c, apperr.ErrVersionConflict)
+		return 0, false
+	}
+	return uint(version), true
+}
+
+// ResponseVersionConflict 版本冲突时返回记录当前数据, current 需设置主键
+func ResponseVersionConflict(c *gin.Context, current models.Versioned) {
+	if !loadDetail(c, current) {
+		return
+	}
+	middleware.RenderErrorData(c, apperr.ErrVersionConflict, present(c, current))
+}
+
+// ResponseUpdated 更新成功后重新读取记录及关联ID返回, 部分更新时请求中只有修改的字段
+func ResponseUpdated(c *gin.Context, updated models.Versioned) {
+	if !loadDetail(c, updated) {
+		return
+	}
+	ResponseJson(c, http.StatusOK, present(c, updated))
+}
+
+// loadDetail 读取完整记录并设置ETag, 失败时已返回错误
+func loadDetail(c *gin.Context, record models.Versioned) bool {
+	if err := models.DetailFrom(GetRequestDB(c), record); err != nil {
+		ResponseError(c, apperr.ErrNotFound.WithCause(err))
+		return false
+	}
+	if err := record.LoadAllAssociationIds(GetRequestDB(c)); err != nil {
+		ResponseError(c, err)
+		return false
+	}
+	SetETag(c, record.GetVersion())
+	return true
+}
+
+// ResponseList 列表分页, 带 cursor 参数时使用游标分页, 否则使用页码分页
+// 游标分页: ?cursor=(首页为空)&pagesize=&count=true, 返回 next_cursor/prev_cursor 及 Link 头
+// 页码分页: ?page=&pagesize=, 返回 count
+func ResponseList(c *gin.Context, results interface{}, fields models.QueryFields, scopes ...func(*gorm.DB) *gorm.DB) {
+	query, err := fields.Parse(c.Request.URL.Query())
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	pageSize := utils.StrTo(c.Query("pagesize")).Uint()
+	// 未指定 fields 时只返回 DefaultSelect 查询的字段, 不输出未查询字段的零值
+	sparse := fields.ListSparse(query.Sparse)
+
+	cursor, cursorMode := c.GetQuery("cursor")
+	if !cursorMode {
+		var count uint
+		page := utils.StrTo(c.Query("page")).Uint()
+		err = models.ListPageSearchFilterOrder(GetRequestDB(c), results, &count, page, pageSize, fields, query, scopes...)
+		if err != nil {
+			ResponseError(c, err)
+			return
+		}
+		ResponseJsonMore(c, http.StatusOK, sparseData(present(c, results), sparse), map[string]interface{}{"count": count})
+		return
+	}
+
+	if pageSize == 0 {
+		pageSize = config.AppConfig.PageSize
+	}
+	moreInfo := map[string]interface{}{}
+	var count *uint
+	if c.Query("count") == "true" {
+		count = new(uint)
+	}
+	cursorPage, err := models.ListCursor(GetRequestDB(c), results, count, cursor, pageSize, fields, query, scopes...)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+
+	var links []string
+	if cursorPage.NextCursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, cursorURL(c, cursorPage.NextCursor)))
+	}
+	if cursorPage.PrevCursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, cursorURL(c, cursorPage.PrevCursor)))
+	}
+	if len(links) != 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+
+	moreInfo["next_cursor"] = cursorPage.NextCursor
+	moreInfo["prev_cursor"] = cursorPage.PrevCursor
+	if count != nil {
+		moreInfo["count"] = *count
+	}
+	ResponseJsonMore(c, http.StatusOK, sparseData(present(c, results), sparse), moreInfo)
+}
+
+// ParseSparse 解析 fields/include 参数, 失败时已写入响应
+func ParseSparse(c *gin.Context, fields models.QueryFields) (*models.Sparse, bool) {
+	sparse, err := fields.ParseSparse(c.Request.URL.Query())
+	if err != nil {
+		ResponseError(c, err)
+		return nil, false
+	}
+	return sparse, true
+}
+
+// viewer 当前登录用户查看其他用户时的视图, 有 manage_user 权限时返回完整资料
+func viewer(c *gin.Context) presenter.Audience {
+	if CheckPermission(c, "manage_user") {
+		return presenter.Admin
 	}
-	return http.StatusText(code)
+	return presenter.Public
+}
+
+// present 将模型转换为当前登录用户可见的视图, 非模型数据原样返回
+func present(c *gin.Context, data interface{}) interface{} {
+	return presenter.Present(data, viewer(c))
+}
+
+// sparseData 指定了 fields 时只保留所选字段及嵌入的关联
+// data 为视图或视图切片
+func sparseData(data interface{}, sparse *models.Sparse) interface{} {
+	if len(sparse.Fields) == 0 {
+		return data
+	}
+	keys := append(append([]string{}, sparse.Fields...), sparse.Includes...)
+	pick := func(item map[string]json.RawMessage) map[string]json.RawMessage {
+		picked := make(map[string]json.RawMessage, len(keys))
+		for _, key := range keys {
+			if v, ok := item[key]; ok {
+				picked[key] = v
+			}
+		}
+		return picked
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var items []map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &items); err == nil {
+		result := make([]map[string]json.RawMessage, 0, len(items))
+		for _, item := range items {
+			result = append(result, pick(item))
+		}
+		return result
+	}
+	var item map[string]json.RawMessage
+	if err = json.Unmarshal(raw, &item); err != nil {
+		return data
+	}
+	return pick(item)
+}
+
+// cursorURL 替换当前请求地址中的 cursor 参数
+func cursorURL(c *gin.Context, cursor string) string {
+	u := *c.Request.URL
+	query := u.Query()
+	query.Set("cursor", cursor)
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
 }
 
 func Ping(c *gin.Context) {
 	ResponseJson(c, http.StatusOK, nil)
 }
+
+// 数据库健康状态及连接池统计
+func DBStatsGet(c *gin.Context) {
+	ResponseJson(c, http.StatusOK, models.GetDBStatus())
+}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/dto"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+// bindBatchIds 解析请求体 {"ids": [1, 2]}, 一次最多1000个, 失败时已写入响应
+func bindBatchIds(c *gin.Context) ([]uint, bool) {
+	var form dto.BatchIds
+	if !bindRequest(c, &form, 0) {
+		return nil, false
+	}
+	return form.Ids, true
+}
+
+// ResponseBatch 返回每个id的结果及实际修改的数量
+func ResponseBatch(c *gin.Context, results []*models.BatchResult) {
+	ResponseJsonMore(c, http.StatusOK, results, map[string]interface{}{"updated": models.CountBatchUpdated(results)})
+}
+
+// ResponseAssociationBatch 批量追加或移除关联, 在一个事务中执行
+func ResponseAssociationBatch(c *gin.Context, owner models.Versioned, association string, remove bool) {
+	if utils.StrTo(c.Param("id")).Uint() == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+	if err := c.ShouldBindUri(owner); err != nil {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+	ids, ok := bindBatchIds(c)
+	if !ok {
+		return
+	}
+
+	var results []*models.BatchResult
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		var err error
+		results, err = models.ChangeAssociationTx(tx, owner, association, ids, remove)
+		return err
+	})
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseBatch(c, results)
+}
+
+func responseUsersActive(c *gin.Context, active bool) {
+	ids, ok := bindBatchIds(c)
+	if !ok {
+		return
+	}
+
+	// 超级管理员只有超级管理员可以启用/禁用, 其他用户操作时该项为 forbidden 并记录审计日志
+	login := GetLoginUser(c)
+	var results []*models.BatchResult
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		var err error
+		results, err = models.SetUsersActiveTx(tx, ids, active, login != nil && login.Superuser)
+		return err
+	})
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	action := "user_activate"
+	if !active {
+		action = "user_deactivate"
+	}
+	for _, result := range results {
+		if result.Status == models.BatchForbidden {
+			entry := &models.AuditLog{Event: models.AuditPermissionDeny, Outcome: models.AuditDenied,
+				TargetType: models.HistoryUser, TargetID: result.ID}
+			entry.SetDetail(map[string]string{"required": "superuser", "action": action})
+			Audit(c, entry)
+		}
+	}
+	ResponseBatch(c, results)
+}
+
+// 批量启用用户, {"ids": [1, 2]}
+func UsersActivate(c *gin.Context) {
+	responseUsersActive(c, true)
+}
+
+// 批量禁用用户, {"ids": [1, 2]}
+func UsersDeactivate(c *gin.Context) {
+	responseUsersActive(c, false)
+}
+
+// 批量添加组成员, {"ids": [用户id]}, 已是成员的用户不重复添加
+func GroupUsersPost(c *gin.Context) {
+	ResponseAssociationBatch(c, &models.Group{}, "Users", false)
+}
+
+// 批量移除组成员, {"ids": [用户id]}
+func GroupUsersDelete(c *gin.Context) {
+	ResponseAssociationBatch(c, &models.Group{}, "Users", true)
+}
+
+// 将权限批量授予组, {"ids": [组id]}
+func PermissionGroupsPost(c *gin.Context) {
+	ResponseAssociationBatch(c, &models.Permission{}, "Groups", false)
+}
+
+// 批量撤销组的权限, {"ids": [组id]}
+func PermissionGroupsDelete(c *gin.Context) {
+	ResponseAssociationBatch(c, &models.Permission{}, "Groups", true)
+}
+
+// ResponseMembership 添加或移除单个关联, 重复添加/移除不报错, 返回该关联的当前id
+// param 为关联记录id的路由参数, 如 uid; 添加不存在的记录时返回404
+func ResponseMembership(c *gin.Context, owner models.Versioned, association string, param string, remove bool) {
+	targetId := utils.StrTo(c.Param(param)).Uint()
+	if utils.StrTo(c.Param("id")).Uint() == 0 || targetId == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+	if err := c.ShouldBindUri(owner); err != nil {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+
+	var membership *models.Membership
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		results, err := models.ChangeAssociationTx(tx, owner, association, []uint{targetId}, remove)
+		if err != nil {
+			return err
+		}
+		if results[0].Status == models.BatchNotFound {
+			return gorm.ErrRecordNotFound
+		}
+		membership, err = models.LoadMembership(tx, owner, association)
+		return err
+	})
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	SetETag(c, membership.Version)
+	ResponseJson(c, http.StatusOK, membership)
+}
+
+// responseUserMembership 修改用户的组/权限, 超级管理员只有超级管理员可以修改
+func responseUserMembership(c *gin.Context, association string, param string, remove bool) {
+	id := utils.StrTo(c.Param("id")).Uint()
+	if id == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+	if !loadManagedUser(c, &models.User{ID: id}, "user_update") {
+		return
+	}
+	ResponseMembership(c, &models.User{}, association, param, remove)
+}
+
+// 将用户加入组
+func UserGroupPost(c *gin.Context) {
+	responseUserMembership(c, "Groups", "gid", false)
+}
+
+// 将用户移出组
+func UserGroupDelete(c *gin.Context) {
+	responseUserMembership(c, "Groups", "gid", true)
+}
+
+// 授予用户权限
+func UserPermissionPost(c *gin.Context) {
+	responseUserMembership(c, "Permissions", "pid", false)
+}
+
+// 撤销用户权限
+func UserPermissionDelete(c *gin.Context) {
+	responseUserMembership(c, "Permissions", "pid", true)
+}
+
+// 添加组成员
+func GroupUserPost(c *gin.Context) {
+	ResponseMembership(c, &models.Group{}, "Users", "uid", false)
+}
+
+// 移除组成员
+func GroupUserDelete(c *gin.Context) {
+	ResponseMembership(c, &models.Group{}, "Users", "uid", true)
+}
+
+// 授予组权限
+func GroupPermissionPost(c *gin.Context) {
+	ResponseMembership(c, &models.Group{}, "Permissions", "pid", false)
+}
+
+// 撤销组权限
+func GroupPermissionDelete(c *gin.Context) {
+	ResponseMembership(c, &models.Group{}, "Permissions", "pid", true)
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+	"github.com/sulin2018/go-web-base/src/middleware"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+const signedURLExpires = 10 * time.Minute
+
+// 本人上传的文件, 或拥有 manage_file 权限
+func canAccessFile(c *gin.Context, file *models.File) bool {
+	user := middleware.GetLoginUser(c)
+	if user == nil {
+		return false
+	}
+	return user.ID == file.OwnerID || middleware.CheckPermission(c, "manage_file")
+}
+
+// 获取文件记录并校验权限, 失败时已写入响应
+func loadFile(c *gin.Context) *models.File {
+	var file models.File
+	if err := c.ShouldBindUri(&file); err != nil || file.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return nil
+	}
+
+	if err := models.DetailFrom(GetRequestDB(c), &file); err != nil {
+		ResponseError(c, apperr.ErrNotFound.WithCause(err))
+		return nil
+	}
+
+	if !canAccessFile(c, &file) {
+		ResponseError(c, apperr.ErrForbidden)
+		return nil
+	}
+	return &file
+}
+
+func serveObject(c *gin.Context, content io.ReadCloser, obj *storage.Object, fileName string, mimeType string) {
+	defer content.Close()
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(fileName))
+	c.Header("Content-Type", mimeType)
+	if rs, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", obj.ModTime, rs)
+		return
+	}
+	c.DataFromReader(http.StatusOK, obj.Size, mimeType, content, nil)
+}
+
+// 上传
+func FilePost(c *gin.Context) {
+	user := middleware.GetLoginUser(c)
+
+	fileHeader, ok := formFile(c, "file", config.AppConfig.FileMaxSize*1024)
+	if !ok {
+		return
+	}
+
+	f, err := fileHeader.Open()
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	defer f.Close()
+
+	// 取文件头判断类型, 再与剩余内容拼接, 边写入存储边计算hash
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		ResponseError(c, err)
+		return
+	}
+	head = head[:n]
+	hasher := sha256.New()
+	reader := io.TeeReader(io.MultiReader(bytes.NewReader(head), f), hasher)
+
+	storageKey, err := storage.NewKey("files")
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	file := models.File{
+		OwnerID:    user.ID,
+		Name:       fileHeader.Filename,
+		StorageKey: storageKey,
+		Size:       fileHeader.Size,
+		MimeType:   http.DetectContentType(head),
+	}
+
+	err = storage.GetStorage().Put(file.StorageKey, reader, file.Size, file.MimeType)
+	if err != nil {
+		logrus.Error(err)
+		ResponseError(c, err)
+		return
+	}
+	file.Hash = hex.EncodeToString(hasher.Sum(nil))
+
+	err = file.Create()
+	if err != nil {
+		_ = storage.GetStorage().Delete(file.StorageKey)
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusCreated, present(c, &file))
+}
+
+// 详情
+func FileGet(c *gin.Context) {
+	file := loadFile(c)
+	if file == nil {
+		return
+	}
+	ResponseJson(c, http.StatusOK, present(c, file))
+}
+
+// 下载
+func FileDownload(c *gin.Context) {
+	file := loadFile(c)
+	if file == nil {
+		return
+	}
+
+	content, obj, err := storage.GetStorage().Get(file.StorageKey)
+	if err == storage.ErrNotFound {
+		ResponseError(c, apperr.ErrNotFound.WithCause(err))
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ResponseError(c, err)
+		return
+	}
+	serveObject(c, content, obj, file.Name, file.MimeType)
+}
+
+// 临时下载地址, 可交给无登录态的客户端使用
+func FileURLGet(c *gin.Context) {
+	file := loadFile(c)
+	if file == nil {
+		return
+	}
+
+	signedURL, err := storage.GetStorage().SignedURL(file.StorageKey, signedURLExpires)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusOK, map[string]interface{}{
+		"url":        signedURL,
+		"expires_at": time.Now().Add(signedURLExpires),
+	})
+}
+
+// 删除
+func FileDelete(c *gin.Context) {
+	file := loadFile(c)
+	if file == nil {
+		return
+	}
+
+	err := file.Delete()
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	err = storage.GetStorage().Delete(file.StorageKey)
+	if err != nil {
+		logrus.Error(err)
+	}
+	ResponseJson(c, http.StatusNoContent, nil)
+}
+
+// 列表, 无 manage_file 权限时只返回本人文件
+func FilesGet(c *gin.Context) {
+	var files []*models.File
+
+	var scopes []func(*gorm.DB) *gorm.DB
+	if !middleware.CheckPermission(c, "manage_file") {
+		scopes = append(scopes, models.DBFilter([]models.Filter{
+			{Column: "owner_id", Op: "eq", Value: middleware.GetLoginUser(c).ID},
+		}))
+	}
+	ResponseList(c, &files, models.FileQueryFields, scopes...)
+}
+
+// 本地存储的签名下载地址
+func StorageSignedGet(c *gin.Context) {
+	local, ok := storage.GetStorage().(*storage.Local)
+	key := c.Query("key")
+	if !ok || !local.VerifySignedURL(key, c.Query("expires"), c.Query("sign")) {
+		ResponseError(c, apperr.ErrForbidden)
+		return
+	}
+
+	file, err := models.GetFileByStorageKey(key)
+	if err != nil {
+		ResponseError(c, apperr.ErrNotFound)
+		return
+	}
+
+	content, obj, err := local.Get(key)
+	if err != nil {
+		ResponseError(c, apperr.ErrNotFound)
+		return
+	}
+	serveObject(c, content, obj, file.Name, file.MimeType)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+// ResponseHistory 记录的变更历史, 默认按版本倒序
+// 支持 ?action=&actor_id=&created_at[gte]= 过滤及分页, 参数同 ResponseList
+func ResponseHistory(c *gin.Context, recordType string) {
+	id := utils.StrTo(c.Param("id")).Uint()
+	if id == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+
+	var histories []*models.RecordHistory
+	ResponseList(c, &histories, models.HistoryQueryFields, models.DBHistoryOf(recordType, id))
+}
+
+// ResponseRevert 回滚到指定版本, 需要 If-Match 版本
+func ResponseRevert(c *gin.Context, recordType string) {
+	id := utils.StrTo(c.Param("id")).Uint()
+	revision := utils.StrTo(c.Param("revision")).Uint()
+	if id == 0 || revision == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+
+	version, ok := IfMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	record, err := models.RevertHistory(GetRequestDB(c), recordType, id, revision, version)
+	switch {
+	case err == nil:
+	case err == models.ErrVersionConflict:
+		switch recordType {
+		case models.HistoryUser:
+			ResponseVersionConflict(c, &models.User{ID: id})
+		case models.HistoryGroup:
+			ResponseVersionConflict(c, &models.Group{ID: id})
+		default:
+			ResponseVersionConflict(c, &models.Permission{ID: id})
+		}
+		return
+	default:
+		ResponseError(c, err)
+		return
+	}
+
+	SetETag(c, record.GetVersion())
+	ResponseJson(c, http.StatusOK, present(c, record))
+}
+
+// 用户变更历史
+func UserHistoryGet(c *gin.Context) {
+	ResponseHistory(c, models.HistoryUser)
+}
+
+// 用户回滚到指定版本, 密码及 superuser 不回滚
+func UserRevert(c *gin.Context) {
+	ResponseRevert(c, models.HistoryUser)
+}
+
+func GroupHistoryGet(c *gin.Context) {
+	ResponseHistory(c, models.HistoryGroup)
+}
+
+func GroupRevert(c *gin.Context) {
+	ResponseRevert(c, models.HistoryGroup)
+}
+
+func PermissionHistoryGet(c *gin.Context) {
+	ResponseHistory(c, models.HistoryPermission)
+}
+
+func PermissionRevert(c *gin.Context) {
+	ResponseRevert(c, models.HistoryPermission)
+}
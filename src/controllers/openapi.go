@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/openapi"
+)
+
+var apiDoc *openapi.Document
+
+// SetAPIDoc 设置接口文档, 在所有路由注册后调用
+func SetAPIDoc(doc *openapi.Document) {
+	apiDoc = doc
+}
+
+// OpenAPI 3 文档, 不使用 ResponseJson 的外层结构
+func OpenAPIGet(c *gin.Context) {
+	if apiDoc == nil {
+		ResponseError(c, apperr.ErrNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, apiDoc)
+}
+
+// 接口文档页面, 仅开发模式注册
+func APIDocsGet(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(openapi.UIPage(config.AppConfig.AppName+" API", "/api/openapi.json")))
+}
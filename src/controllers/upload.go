@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/upload"
+	"github.com/sulin2018/go-web-base/src/dto"
+	"github.com/sulin2018/go-web-base/src/middleware"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// 获取本人的上传任务, 失败时已写入响应
+func loadUpload(c *gin.Context) *models.Upload {
+	var task models.Upload
+	if err := c.ShouldBindUri(&task); err != nil || task.ID == "" {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return nil
+	}
+
+	if err := models.Detail(&task); err != nil {
+		ResponseError(c, apperr.ErrNotFound)
+		return nil
+	}
+
+	if middleware.GetLoginUser(c).ID != task.OwnerID {
+		ResponseError(c, apperr.ErrForbidden)
+		return nil
+	}
+	return &task
+}
+
+// 创建分片上传任务
+func UploadPost(c *gin.Context) {
+	var params dto.UploadCreate
+	if !bindRequest(c, &params, 0) {
+		return
+	}
+
+	task, err := upload.Create(middleware.GetLoginUser(c).ID, params.Name, params.Size, params.Hash)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	c.Header("Upload-Offset", "0")
+	ResponseJson(c, http.StatusCreated, present(c, task))
+}
+
+// 查询上传进度, 断点续传时从返回的 offset 继续
+func UploadGet(c *gin.Context) {
+	task := loadUpload(c)
+	if task == nil {
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(task.Offset, 10))
+	ResponseJson(c, http.StatusOK, present(c, task))
+}
+
+// 上传分片
+// 请求体为分片原始内容, Upload-Offset 头为分片起始位置, Upload-Checksum 头为分片sha256(可选)
+func UploadPatch(c *gin.Context) {
+	task := loadUpload(c)
+	if task == nil {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		ResponseError(c, apperr.ErrInvalidParam.WithKey("error.invalid_upload_offset", nil))
+		return
+	}
+
+	err = upload.WriteChunk(task, offset, c.Request.Body, c.GetHeader("Upload-Checksum"))
+	c.Header("Upload-Offset", strconv.FormatInt(task.Offset, 10))
+	// 位置不一致/分片过大/校验失败/已过期由 ErrorMiddleware 转换为对应的错误码
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusOK, present(c, task))
+}
+
+// 取消上传
+func UploadDelete(c *gin.Context) {
+	task := loadUpload(c)
+	if task == nil {
+		return
+	}
+
+	err := upload.Abort(task)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusNoContent, nil)
+}
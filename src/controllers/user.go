@@ -2,134 +2,95 @@ package controllers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
-	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/avatar"
+	"github.com/sulin2018/go-web-base/src/app/i18n"
+	"github.com/sulin2018/go-web-base/src/dto"
 	"github.com/sulin2018/go-web-base/src/middleware"
 	"github.com/sulin2018/go-web-base/src/models"
-	"github.com/sulin2018/go-web-base/src/utils"
+	"github.com/sulin2018/go-web-base/src/presenter"
 )
 
 func GetLoginUser(c *gin.Context) *models.User {
-	username, err := middleware.GetSession(c, "username")
-	if err != nil || username == "" {
-		return nil
-	}
-
-	user := models.User{Username: username.(string)}
-	err = models.Detail(user)
-	if err != nil {
-		return nil
-	}
-
-	return &user
+	return middleware.GetLoginUser(c)
 }
 
 func CheckPermission(c *gin.Context, permName string) bool {
-	user := GetLoginUser(c)
-	if user == nil {
-		return false
-	}
-
-	if user.Superuser {
-		return true
-	}
-
-	tempPerm := &models.Permission{Name: permName}
-	err := models.LoadColumns(tempPerm, []string{"id"})
-	if err != nil {
-		return false
-	}
-
-	// 先从用户关联权限寻找
-	err = user.LoadPermAssociationIds()
-	if err != nil {
-		return false
-	}
-	for _, permId := range user.PermissionIds {
-		if permId == tempPerm.ID {
-			return true
-		}
-	}
+	return middleware.CheckPermission(c, permName)
+}
 
-	// 从关联组里面寻找是否有对应权限
-	err = user.LoadGroupAssociationIds()
-	if err != nil {
-		return false
-	}
-	err = tempPerm.LoadGroupAssociationIds()
-	if err != nil {
-		return false
-	}
-	for _, userGroupId := range user.GroupIds {
-		for _, permGroupId := range tempPerm.GroupIds {
-			if userGroupId == permGroupId {
-				return true
-			}
-		}
-	}
+func GetRequestDB(c *gin.Context) *gorm.DB {
+	return middleware.GetRequestDB(c)
+}
 
-	return false
+// RequestTx 在当前请求的连接上执行事务, 变更历史中记录当前登录用户为操作人
+func RequestTx(c *gin.Context, fn func(tx *gorm.DB) error) error {
+	return models.WithTxFrom(GetRequestDB(c), fn)
 }
 
 // 详情
 func UserGet(c *gin.Context) {
 	var user models.User
-	err := c.BindUri(&user)
+	err := c.ShouldBindUri(&user)
 	if err != nil {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	err = models.Detail(&user)
-	if err != nil {
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	sparse, ok := ParseSparse(c, models.UserQueryFields)
+	if !ok {
 		return
 	}
 
-	err = user.LoadAllAssociationIds()
+	// Select 白名单中没有password, 不会查询出来传递给客户端
+	err = models.DetailFrom(GetRequestDB(c), &user, models.UserQueryFields.DBSparse(&user, sparse, "version"))
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 
-	ResponseJson(c, http.StatusOK, struct {
-		*models.User
-		// 忽略password 不传递给客户端
-		Password bool `json:"password,omitempty"`
-	}{
-		User: &user,
-	})
+	// 指定 fields 时只返回所选字段, 不加载关联id
+	if len(sparse.Fields) == 0 {
+		err = user.LoadAllAssociationIds(GetRequestDB(c))
+		if err != nil {
+			ResponseError(c, err)
+			return
+		}
+	}
+
+	SetETag(c, user.Version)
+	ResponseJson(c, http.StatusOK, sparseData(present(c, &user), sparse))
 }
 
 // 新增
 func UserPost(c *gin.Context) {
-	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "参数错误: "+err.Error())
+	var req dto.UserCreate
+	if !bindRequest(c, &req, 0) || !checkSuperuserField(c, req.Superuser) {
 		return
 	}
 
-	if user.Password == "" {
-		user.Password = config.AppConfig.UserBasePassword
-	}
-
+	user := req.User()
 	err := user.EncryptPassword()
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 
-	err = user.Create()
+	err = RequestTx(c, func(tx *gorm.DB) error {
+		return user.CreateValuesTx(tx, req.Values())
+	})
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 
-	ResponseJson(c, http.StatusCreated, user)
+	ResponseJson(c, http.StatusCreated, present(c, user))
 }
 
 // 更新
@@ -138,23 +99,34 @@ func UserPatch(c *gin.Context) {
 
 	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	var newUserData models.User
-	if err := c.ShouldBindJSON(&newUserData); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	version, ok := IfMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	// 密码通过 /user/:id/password 修改, 记录审计日志
+	var req dto.UserPatch
+	if !bindRequest(c, &req, user.ID) || !checkSuperuserField(c, req.Superuser) ||
+		!loadManagedUser(c, &models.User{ID: user.ID}, "user_update") {
 		return
 	}
 
-	err := models.UpdateByMapOrStruct(&user, &newUserData)
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return models.UpdateByMapOrStructTx(tx, &user, req.Values(), version)
+	})
+	if err == models.ErrVersionConflict {
+		ResponseVersionConflict(c, &models.User{ID: user.ID})
+		return
+	}
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusOK, user)
+	ResponseUpdated(c, &models.User{ID: user.ID})
 }
 
 // 全量更新
@@ -163,22 +135,36 @@ func UserPut(c *gin.Context) {
 
 	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	if err := c.ShouldBindJSON(&user); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	version, ok := IfMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	err := user.Update()
+	var req dto.UserPut
+	if !bindRequest(c, &req, user.ID) || !checkSuperuserField(c, req.Superuser) ||
+		!loadManagedUser(c, &models.User{ID: user.ID}, "user_update") {
+		return
+	}
+	user.Version = version
+	user.GroupIds = req.GroupIds
+	user.PermissionIds = req.PermissionIds
+
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return user.UpdateValuesTx(tx, req.Values())
+	})
+	if err == models.ErrVersionConflict {
+		ResponseVersionConflict(c, &models.User{ID: user.ID})
+		return
+	}
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusOK, user)
+	ResponseUpdated(c, &models.User{ID: user.ID})
 }
 
 // 删除
@@ -186,264 +172,549 @@ func UserDelete(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
+		return
+	}
+	if !loadManagedUser(c, &models.User{ID: user.ID}, "user_delete") {
 		return
 	}
 
-	err := user.Delete()
+	err := RequestTx(c, user.DeleteTx)
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 	ResponseJson(c, http.StatusNoContent, nil)
 }
 
-// 列表
-func UsersGet(c *gin.Context) {
-	var users []*models.User
-	var count uint
+// 恢复已删除用户
+func UserRestore(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+
+	// 未删除为 404 not_deleted, 用户名已被占用为 409 username_taken
+	err := RequestTx(c, user.RestoreTx)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusOK, present(c, &user))
+}
+
+// 彻底删除, 只能删除已软删除的用户
+func UserPurge(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
 
-	page := utils.StrTo(c.Query("page")).Uint()
-	pageSize := utils.StrTo(c.Query("pagesize")).Uint()
-	err := models.PageColumns(&users, &count, page, pageSize, "id, username, chinese_name, active, superuser, created_at, updated_at")
+	err := RequestTx(c, user.PurgeTx)
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
+	if user.Avatar != "" && !models.AvatarInUse(user.Avatar) {
+		_ = avatar.Remove(user.Avatar)
+	}
+	ResponseJson(c, http.StatusNoContent, nil)
+}
+
+// 列表, deleted=true 时返回已删除的用户
+func UsersGet(c *gin.Context) {
+	var users []*models.User
 
-	ResponseJsonMore(c, http.StatusOK, users, map[string]interface{}{"count": count})
+	var scopes []func(*gorm.DB) *gorm.DB
+	if c.Query("deleted") == "true" {
+		scopes = append(scopes, models.DBDeleted())
+	}
+	ResponseList(c, &users, models.UserQueryFields, scopes...)
 }
 
 // 登录
 func UserLogin(c *gin.Context) {
+	var req dto.UserLogin
+	if !bindRequest(c, &req, 0) {
+		return
+	}
+
+	user := models.User{Username: req.Username, Password: req.Password}
+	if !user.CheckPassword() {
+		Audit(c, &models.AuditLog{Event: models.AuditLoginFailure, Outcome: models.AuditFailure,
+			TargetType: models.HistoryUser, TargetName: user.Username})
+		ResponseError(c, apperr.ErrLoginFailed)
+		return
+	}
+
+	// 登录时间早于 SessionsRevokedAt 的会话失效
+	err := middleware.SetSession(c, "login_at", time.Now().Unix())
+	if err == nil {
+		err = middleware.SetSession(c, "username", user.Username)
+	}
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+
+	loginUser := GetLoginUser(c)
+	if loginUser == nil {
+		ResponseError(c, apperr.ErrInternal)
+		return
+	}
+	Audit(c, &models.AuditLog{Event: models.AuditLoginSuccess, Outcome: models.AuditSuccess,
+		TargetType: models.HistoryUser, TargetID: loginUser.ID, TargetName: loginUser.Username})
+
+	err = loginUser.LoadAllAssociations()
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+
+	ResponseJson(c, http.StatusOK, presenter.NewUserSelf(loginUser))
+}
+
+// 退出登录
+func UserLogout(c *gin.Context) {
+	if user := GetLoginUser(c); user != nil {
+		Audit(c, &models.AuditLog{Event: models.AuditLogout, Outcome: models.AuditSuccess,
+			TargetType: models.HistoryUser, TargetID: user.ID, TargetName: user.Username})
+	}
+	if err := middleware.ClearSession(c); err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusNoContent, nil)
+}
+
+// 修改自己的密码, 需要原密码
+func UserPasswordPut(c *gin.Context) {
+	var form dto.PasswordChange
+	if !bindRequest(c, &form, 0) {
+		return
+	}
+
+	user := GetLoginUser(c)
+	if user == nil {
+		ResponseError(c, apperr.ErrUnauthorized)
+		return
+	}
+	entry := &models.AuditLog{Event: models.AuditPasswordChange, TargetType: models.HistoryUser,
+		TargetID: user.ID, TargetName: user.Username}
+
+	check := models.User{Username: user.Username, Password: form.OldPassword}
+	if !check.CheckPassword() {
+		entry.Outcome = models.AuditFailure
+		entry.SetDetail(map[string]string{"reason": "wrong old password"})
+		Audit(c, entry)
+		ResponseError(c, apperr.ErrWrongPassword)
+		return
+	}
+
+	if err := user.SetPassword(form.NewPassword); err != nil {
+		ResponseError(c, err)
+		return
+	}
+	entry.Outcome = models.AuditSuccess
+	Audit(c, entry)
+	ResponseJson(c, http.StatusNoContent, nil)
+}
+
+// 设置自己的语言, 为空时按请求或默认语言
+func UserLocalePut(c *gin.Context) {
+	var form dto.UserLocale
+	if !bindRequest(c, &form, 0) {
+		return
+	}
+	form.Locale, _ = i18n.Supported(form.Locale)
+
+	user := GetLoginUser(c)
+	if user == nil {
+		ResponseError(c, apperr.ErrUnauthorized)
+		return
+	}
+	if err := user.SetLocaleTx(GetRequestDB(c), form.Locale); err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusOK, map[string]interface{}{"locale": form.Locale, "locales": i18n.Locales()})
+}
+
+// 管理员重置用户密码, 超级管理员的密码只有超级管理员可以重置
+func UserPasswordPost(c *gin.Context) {
 	var user models.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "参数错误: "+err.Error())
+	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+	var form dto.PasswordReset
+	if !bindRequest(c, &form, 0) || !loadManagedUser(c, &user, models.AuditPasswordChange) {
 		return
 	}
 
-	if user.CheckPassword() {
-		err := middleware.SetSession(c, "username", user.Username)
-		if err != nil {
-			ResponseJson(c, http.StatusInternalServerError, err.Error())
-			return
-		}
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return user.SetPasswordTx(tx, form.Password)
+	})
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	Audit(c, &models.AuditLog{Event: models.AuditPasswordChange, Outcome: models.AuditSuccess,
+		TargetType: models.HistoryUser, TargetID: user.ID, TargetName: user.Username})
+	ResponseJson(c, http.StatusNoContent, nil)
+}
 
-		err = user.LoadAllAssociations()
-		if err != nil {
-			ResponseJson(c, http.StatusInternalServerError, err.Error())
-			return
-		}
+// 撤销用户已登录的全部会话, 超级管理员的会话只有超级管理员可以撤销
+func UserSessionsDelete(c *gin.Context) {
+	var user models.User
+	if err := c.ShouldBindUri(&user); err != nil || user.ID == 0 {
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
+	if !loadManagedUser(c, &user, models.AuditSessionRevoke) {
+		return
+	}
+
+	err := RequestTx(c, user.RevokeSessionsTx)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	Audit(c, &models.AuditLog{Event: models.AuditSessionRevoke, Outcome: models.AuditSuccess,
+		TargetType: models.HistoryUser, TargetID: user.ID, TargetName: user.Username})
+	ResponseJson(c, http.StatusNoContent, nil)
+}
 
-		user.Password = ""
-		ResponseJson(c, http.StatusOK, user)
-	} else {
-		ResponseJson(c, http.StatusBadRequest, "账号或密码错误")
+// loadManagedUser 加载要操作的用户, 目标为超级管理员而当前用户不是时返回403并记录审计日志
+// action 为被拒绝的操作, 如 password_change; 失败时已写入响应
+func loadManagedUser(c *gin.Context, user *models.User, action string) bool {
+	err := models.DetailFrom(GetRequestDB(c), user, func(db *gorm.DB) *gorm.DB {
+		return db.Select([]string{"id", "username", "superuser"})
+	})
+	if err != nil {
+		ResponseError(c, err)
+		return false
+	}
+	if login := GetLoginUser(c); user.Superuser && (login == nil || !login.Superuser) {
+		entry := &models.AuditLog{Event: models.AuditPermissionDeny, Outcome: models.AuditDenied,
+			TargetType: models.HistoryUser, TargetID: user.ID, TargetName: user.Username}
+		entry.SetDetail(map[string]string{"required": "superuser", "action": action})
+		Audit(c, entry)
+		ResponseError(c, apperr.ErrForbidden.WithKey("error.superuser_target", nil))
+		return false
 	}
+	return true
 }
 
 func GroupGet(c *gin.Context) {
 	var group models.Group
 	if err := c.ShouldBindUri(&group); err != nil {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	err := models.Detail(&group)
-	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+	sparse, ok := ParseSparse(c, models.GroupQueryFields)
+	if !ok {
 		return
 	}
 
-	err = group.LoadAllAssociationIds()
+	err := models.DetailFrom(GetRequestDB(c), &group, models.GroupQueryFields.DBSparse(&group, sparse, "version"))
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusOK, group)
+
+	if len(sparse.Fields) == 0 {
+		err = group.LoadAllAssociationIds(GetRequestDB(c))
+		if err != nil {
+			ResponseError(c, err)
+			return
+		}
+	}
+	SetETag(c, group.Version)
+	ResponseJson(c, http.StatusOK, sparseData(present(c, &group), sparse))
 }
 
 func GroupPut(c *gin.Context) {
 	var group models.Group
 	if err := c.ShouldBindUri(&group); err != nil {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	if err := c.ShouldBindJSON(&group); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	version, ok := IfMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	err := group.Update()
+	var req dto.GroupPut
+	if !bindRequest(c, &req, group.ID) {
+		return
+	}
+	group.Version = version
+	group.UserIds = req.UserIds
+	group.PermissionIds = req.PermissionIds
+
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return group.UpdateValuesTx(tx, req.Values())
+	})
+	if err == models.ErrVersionConflict {
+		ResponseVersionConflict(c, &models.Group{ID: group.ID})
+		return
+	}
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusOK, group)
+	ResponseUpdated(c, &models.Group{ID: group.ID})
 }
 
 func GroupPost(c *gin.Context) {
-	var group models.Group
-	if err := c.ShouldBindJSON(&group); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "参数错误: "+err.Error())
+	var req dto.GroupCreate
+	if !bindRequest(c, &req, 0) {
 		return
 	}
 
-	err := group.Create()
+	group := req.Group()
+	err := RequestTx(c, group.CreateTx)
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusCreated, group)
+	ResponseJson(c, http.StatusCreated, present(c, group))
 }
 
 func GroupPatch(c *gin.Context) {
 	var group models.Group
 	if err := c.ShouldBindUri(&group); err != nil || group.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	var newData models.Group
-	if err := c.ShouldBindJSON(&newData); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	version, ok := IfMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	if err := models.UpdateByMapOrStruct(&group, &newData); err != nil {
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	var req dto.GroupPatch
+	if !bindRequest(c, &req, group.ID) {
 		return
 	}
-	ResponseJson(c, http.StatusOK, group)
+
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return models.UpdateByMapOrStructTx(tx, &group, req.Values(), version)
+	})
+	if err == models.ErrVersionConflict {
+		ResponseVersionConflict(c, &models.Group{ID: group.ID})
+		return
+	}
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseUpdated(c, &models.Group{ID: group.ID})
 }
 
 func GroupDelete(c *gin.Context) {
 	var group models.Group
 	if err := c.ShouldBindUri(&group); err != nil || group.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	err := group.Delete()
+	err := RequestTx(c, group.DeleteTx)
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 	ResponseJson(c, http.StatusNoContent, nil)
 }
 
-func GroupsGet(c *gin.Context) {
-	var group []*models.Group
-	var count uint
+// 恢复已删除的组, 同时恢复其成员和权限
+func GroupRestore(c *gin.Context) {
+	var group models.Group
+	if err := c.ShouldBindUri(&group); err != nil || group.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
 
-	page := utils.StrTo(c.Query("page")).Uint()
-	pageSize := utils.StrTo(c.Query("pagesize")).Uint()
-	err := models.Page(&group, &count, page, pageSize)
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return models.RestoreTx(tx, &group)
+	})
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 
-	ResponseJsonMore(c, http.StatusOK, group, map[string]interface{}{"count": count})
+	err = group.LoadAllAssociationIds(GetRequestDB(c))
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusOK, present(c, &group))
+}
+
+// 列表, deleted=true 时返回已删除的组
+func GroupsGet(c *gin.Context) {
+	var group []*models.Group
+
+	var scopes []func(*gorm.DB) *gorm.DB
+	if c.Query("deleted") == "true" {
+		scopes = append(scopes, models.DBDeleted())
+	}
+	ResponseList(c, &group, models.GroupQueryFields, scopes...)
 }
 
 func PermissionGet(c *gin.Context) {
 	var permission models.Permission
 	if err := c.ShouldBindUri(&permission); err != nil {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	err := models.Detail(&permission)
-	if err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, err.Error())
+	sparse, ok := ParseSparse(c, models.PermissionQueryFields)
+	if !ok {
 		return
 	}
 
-	err = permission.LoadAllAssociationIds()
+	err := models.DetailFrom(GetRequestDB(c), &permission, models.PermissionQueryFields.DBSparse(&permission, sparse, "version"))
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		logrus.Error(err)
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusOK, permission)
+
+	if len(sparse.Fields) == 0 {
+		err = permission.LoadAllAssociationIds(GetRequestDB(c))
+		if err != nil {
+			ResponseError(c, err)
+			return
+		}
+	}
+	SetETag(c, permission.Version)
+	ResponseJson(c, http.StatusOK, sparseData(present(c, &permission), sparse))
 }
 
 func PermissionPost(c *gin.Context) {
-	var permission models.Permission
-	if err := c.ShouldBindJSON(&permission); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "参数错误: "+err.Error())
+	var req dto.PermissionCreate
+	if !bindRequest(c, &req, 0) {
 		return
 	}
 
-	err := permission.Create()
+	permission := req.Permission()
+	err := RequestTx(c, permission.CreateTx)
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusCreated, permission)
+	ResponseJson(c, http.StatusCreated, present(c, permission))
 }
 
 func PermissionPatch(c *gin.Context) {
 	var permission models.Permission
 	if err := c.ShouldBindUri(&permission); err != nil || permission.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	if err := c.ShouldBindJSON(&permission); err != nil {
-		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "参数错误: "+err.Error())
+	version, ok := IfMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	var req dto.PermissionPatch
+	if !bindRequest(c, &req, permission.ID) {
 		return
 	}
+	permission.Version = version
+	permission.GroupIds = req.GroupIds
+	permission.UserIds = req.UserIds
 
-	err := permission.Update()
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return permission.UpdateValuesTx(tx, req.Values())
+	})
+	if err == models.ErrVersionConflict {
+		ResponseVersionConflict(c, &models.Permission{ID: permission.ID})
+		return
+	}
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
-	ResponseJson(c, http.StatusOK, permission)
+	ResponseUpdated(c, &models.Permission{ID: permission.ID})
 }
 
 func PermissionDelete(c *gin.Context) {
 	var permission models.Permission
 	if err := c.ShouldBindUri(&permission); err != nil || permission.ID == 0 {
 		logrus.Error(err)
-		ResponseJson(c, http.StatusBadRequest, "ID错误: "+err.Error())
+		ResponseError(c, apperr.ErrInvalidID.WithCause(err))
 		return
 	}
 
-	err := permission.Delete()
+	err := RequestTx(c, permission.DeleteTx)
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 	ResponseJson(c, http.StatusNoContent, nil)
 }
 
-func PermissionsGet(c *gin.Context) {
-	var permissions []*models.Permission
-	var count uint
+// 恢复已删除的权限, 同时恢复其关联的用户和组
+func PermissionRestore(c *gin.Context) {
+	var permission models.Permission
+	if err := c.ShouldBindUri(&permission); err != nil || permission.ID == 0 {
+		logrus.Error(err)
+		ResponseError(c, apperr.ErrInvalidID)
+		return
+	}
 
-	page := utils.StrTo(c.Query("page")).Uint()
-	pageSize := utils.StrTo(c.Query("pagesize")).Uint()
-	err := models.Page(&permissions, &count, page, pageSize)
+	err := RequestTx(c, func(tx *gorm.DB) error {
+		return models.RestoreTx(tx, &permission)
+	})
 	if err != nil {
-		ResponseJson(c, http.StatusInternalServerError, err.Error())
+		ResponseError(c, err)
 		return
 	}
 
-	ResponseJsonMore(c, http.StatusOK, permissions, map[string]interface{}{"count": count})
+	err = permission.LoadAllAssociationIds(GetRequestDB(c))
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	ResponseJson(c, http.StatusOK, present(c, &permission))
+}
+
+// 列表, deleted=true 时返回已删除的权限
+func PermissionsGet(c *gin.Context) {
+	var permissions []*models.Permission
+
+	var scopes []func(*gorm.DB) *gorm.DB
+	if c.Query("deleted") == "true" {
+		scopes = append(scopes, models.DBDeleted())
+	}
+	ResponseList(c, &permissions, models.PermissionQueryFields, scopes...)
+}
+
+// checkSuperuserField superuser 字段只有超级管理员可以设置, 失败时已写入响应
+func checkSuperuserField(c *gin.Context, superuser *bool) bool {
+	if superuser == nil {
+		return true
+	}
+	if user := GetLoginUser(c); user == nil || !user.Superuser {
+		ResponseError(c, apperr.ErrForbidden.WithKey("error.superuser_required", nil))
+		return false
+	}
+	return true
 }
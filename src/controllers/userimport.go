@@ -0,0 +1,416 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/middleware"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/xuri/excelize/v2"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// clearGroups groups 列为该值时移出所有组, 空值表示不修改
+const clearGroups = "-"
+
+// 导入文件的列, 导出的 id/created_at/updated_at 列在导入时忽略, 导出的文件可直接修改后导入
+var (
+	userImportColumns = []string{"username", "password", "chinese_name", "phone", "active", "superuser", "groups"}
+	userExportColumns = []string{"id", "username", "chinese_name", "phone", "active", "superuser", "groups", "created_at", "updated_at"}
+)
+
+// userFileFormat 依次按 format 参数/上传文件扩展名/Content-Type 判断格式
+func userFileFormat(c *gin.Context, filename string) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+	if ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), "."); ext != "" {
+		return ext
+	}
+	switch c.ContentType() {
+	case "text/csv":
+		return "csv"
+	case xlsxContentType:
+		return "xlsx"
+	case "application/json":
+		return "json"
+	}
+	return ""
+}
+
+// 批量导入用户, 文件通过表单字段 file 上传, 或直接作为请求体
+// ?format=csv|xlsx|json 未指定时按文件扩展名或 Content-Type 判断
+// ?dry_run=true 只校验, 返回每行的结果及错误; ?atomic=true 任一行失败时全部不写入
+// CSV/XLSX 第一行为列名, groups 列为逗号分隔的组名, 为空时不修改, 为 - 时移出所有组
+func UsersImport(c *gin.Context) {
+	maxSize := config.AppConfig.UserImportMaxSize * 1024
+	var reader io.Reader
+	var filename string
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fileHeader, ok := formFile(c, "file", maxSize)
+		if !ok {
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			ResponseError(c, err)
+			return
+		}
+		defer file.Close()
+		reader, filename = file, fileHeader.Filename
+	} else {
+		reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+	}
+
+	var rows []*models.UserImportRow
+	var err error
+	switch userFileFormat(c, filename) {
+	case "csv":
+		rows, err = parseUserCSV(reader)
+	case "xlsx":
+		rows, err = parseUserXLSX(reader)
+	case "json":
+		rows, err = parseUserJSON(reader)
+	default:
+		ResponseError(c, apperr.ErrInvalidParam.WithKey("error.invalid_format", map[string]interface{}{"formats": "csv/xlsx/json"}))
+		return
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		ResponseError(c, apperr.ErrTooLarge)
+		return
+	}
+	if err != nil {
+		ResponseError(c, apperr.ErrInvalidParam.WithKey("error.file_parse_failed", map[string]interface{}{"reason": err.Error()}).WithCause(err))
+		return
+	}
+	if len(rows) == 0 {
+		ResponseError(c, apperr.ErrInvalidParam.WithKey("error.empty_file", nil))
+		return
+	}
+	if maxRows := config.AppConfig.UserImportMaxRows; maxRows > 0 && len(rows) > maxRows {
+		ResponseError(c, apperr.ErrInvalidParam.WithKey("error.too_many_rows", map[string]interface{}{"max": maxRows}))
+		return
+	}
+
+	for _, row := range rows {
+		// superuser 列只有超级管理员可以导入
+		if !checkSuperuserField(c, row.Superuser) {
+			return
+		}
+		// 用户名/手机号格式同 dto.UserCreate, 长度等在 ImportUsers 中校验
+		if err := binding.Validator.ValidateStruct(row); err != nil {
+			row.Invalid = append(row.Invalid, invalidParam(err).Details...)
+		}
+	}
+
+	// 已有的超级管理员只有超级管理员可以修改
+	login := GetLoginUser(c)
+	report, err := models.ImportUsers(GetRequestDB(c), rows, c.Query("dry_run") == "true", c.Query("atomic") == "true",
+		login != nil && login.Superuser)
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+	localizeImportReport(c, report)
+	if report.Atomic && !report.DryRun && !report.Applied {
+		middleware.RenderErrorData(c, apperr.ErrImportFailed, report)
+		return
+	}
+	ResponseJson(c, http.StatusOK, report)
+}
+
+func parseUserCSV(reader io.Reader) ([]*models.UserImportRow, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return parseUserTable(records)
+}
+
+// parseUserXLSX 读取第一个工作表
+func parseUserXLSX(reader io.Reader) ([]*models.UserImportRow, error) {
+	file, err := excelize.OpenReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sheets := file.GetSheetList()
+	if len(sheets) == 0 {
+		return nil, errors.New("no sheet")
+	}
+	records, err := file.GetRows(sheets[0])
+	if err != nil {
+		return nil, err
+	}
+	return parseUserTable(records)
+}
+
+func parseUserJSON(reader io.Reader) ([]*models.UserImportRow, error) {
+	var rows []*models.UserImportRow
+	if err := json.NewDecoder(reader).Decode(&rows); err != nil {
+		return nil, err
+	}
+	for i, row := range rows {
+		if row == nil {
+			return nil, fmt.Errorf("item %d is null", i+1)
+		}
+		row.Row = i + 1
+	}
+	return rows, nil
+}
+
+// parseUserTable 第一行为列名, 空行忽略, 行号从1开始(包括列名行)
+func parseUserTable(records [][]string) ([]*models.UserImportRow, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := make([]string, len(records[0]))
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !contains(userImportColumns, name) && !contains(userExportColumns, name) {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+		header[i] = name
+	}
+	if !contains(header, "username") {
+		return nil, errors.New("column username required")
+	}
+
+	var rows []*models.UserImportRow
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		row := &models.UserImportRow{Row: i + 2}
+		for j, name := range header {
+			value := ""
+			if j < len(record) {
+				value = strings.TrimSpace(record[j])
+			}
+			switch name {
+			case "username":
+				row.Username = value
+			case "password":
+				row.Password = value
+			case "chinese_name":
+				row.ChineseName = value
+			case "phone":
+				row.Phone = value
+			case "active":
+				row.Active = parseImportBool(row, name, value)
+			case "superuser":
+				row.Superuser = parseImportBool(row, name, value)
+			case "groups":
+				row.Groups = splitGroupNames(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// localizeImportReport 按请求的语言填写每行的错误提示, 写入时的数据库错误转换为错误码
+func localizeImportReport(c *gin.Context, report *models.UserImportReport) {
+	for _, result := range report.Rows {
+		middleware.LocalizeFieldErrors(c, result.Errors)
+		if result.Err != nil {
+			appErr := middleware.ToAppError(result.Err)
+			if appErr.Status >= 500 {
+				logrus.Errorf("import user %s: %v", result.Username, result.Err)
+			}
+			result.Errors = append(result.Errors, apperr.FieldError{Code: appErr.Code,
+				Message: middleware.T(c, appErr.MessageKey(), appErr.Params, appErr.Message)})
+		}
+	}
+}
+
+// parseImportBool 空值表示不修改, 格式错误时记录到该行
+func parseImportBool(row *models.UserImportRow, column string, value string) *bool {
+	var b bool
+	switch strings.ToLower(value) {
+	case "":
+		return nil
+	case "true", "1", "yes", "y", "是":
+		b = true
+	case "false", "0", "no", "n", "否":
+		b = false
+	default:
+		row.Invalid = append(row.Invalid, apperr.FieldError{Field: column, Code: "bool", Params: map[string]interface{}{"value": value}})
+		return nil
+	}
+	return &b
+}
+
+// splitGroupNames 组名以逗号或分号分隔, 没有组名时返回 nil 表示不修改, clearGroups 返回空列表
+func splitGroupNames(value string) []string {
+	if value == clearGroups {
+		return []string{}
+	}
+	var names []string
+	for _, name := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 导出用户, ?format=csv|xlsx|json, 搜索/过滤/排序参数同列表, 不包括已删除的用户
+func UsersExport(c *gin.Context) {
+	query, err := models.UserQueryFields.Parse(c.Request.URL.Query())
+	if err != nil {
+		ResponseError(c, err)
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	filename := "users-" + time.Now().Format("20060102150405") + "." + format
+	// superuser 列只有超级管理员可以导入, 其他人导出时留空, 导出的文件可直接导入
+	loginUser := GetLoginUser(c)
+	withSuperuser := loginUser != nil && loginUser.Superuser
+	record := func(user *models.User, groups []string) []string {
+		superuser := ""
+		if withSuperuser {
+			superuser = strconv.FormatBool(user.Superuser)
+		}
+		return []string{
+			strconv.FormatUint(uint64(user.ID), 10), user.Username, user.ChineseName, user.Phone,
+			strconv.FormatBool(user.Active), superuser, strings.Join(groups, ","),
+			user.CreatedAt.Format(time.RFC3339), user.UpdatedAt.Format(time.RFC3339),
+		}
+	}
+
+	var write func(user *models.User, groups []string) error
+	var finish func() error
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(c.Writer)
+		write = func(user *models.User, groups []string) error {
+			return writer.Write(record(user, groups))
+		}
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Status(http.StatusOK)
+		if err = writer.Write(userExportColumns); err != nil {
+			return
+		}
+	case "json":
+		// 逐个输出数组元素, 不在内存中拼接整个列表
+		first := true
+		write = func(user *models.User, groups []string) error {
+			prefix := ","
+			if first {
+				prefix, first = "[", false
+			}
+			if groups == nil {
+				groups = []string{}
+			}
+			values := map[string]interface{}{
+				"id": user.ID, "username": user.Username, "chinese_name": user.ChineseName, "phone": user.Phone,
+				"active": user.Active, "groups": groups,
+				"created_at": user.CreatedAt, "updated_at": user.UpdatedAt,
+			}
+			if withSuperuser {
+				values["superuser"] = user.Superuser
+			}
+			item, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+			_, err = c.Writer.Write(append([]byte(prefix), item...))
+			return err
+		}
+		finish = func() error {
+			end := "]"
+			if first {
+				end = "[]"
+			}
+			_, err := c.Writer.WriteString(end)
+			return err
+		}
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Status(http.StatusOK)
+	case "xlsx":
+		// xlsx 为 zip 格式, 行数据由 StreamWriter 写入临时文件, 最后一次输出
+		file := excelize.NewFile()
+		defer file.Close()
+		stream, err := file.NewStreamWriter("Sheet1")
+		if err != nil {
+			ResponseError(c, err)
+			return
+		}
+		line := 1
+		writeRow := func(values []string) error {
+			cells := make([]interface{}, len(values))
+			for i, v := range values {
+				cells[i] = v
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, line)
+			line++
+			return stream.SetRow(cell, cells)
+		}
+		if err = writeRow(userExportColumns); err != nil {
+			ResponseError(c, err)
+			return
+		}
+		write = func(user *models.User, groups []string) error {
+			return writeRow(record(user, groups))
+		}
+		finish = func() error {
+			if err := stream.Flush(); err != nil {
+				return err
+			}
+			c.Header("Content-Type", xlsxContentType)
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			c.Status(http.StatusOK)
+			return file.Write(c.Writer)
+		}
+	default:
+		ResponseError(c, apperr.ErrInvalidParam.WithKey("error.invalid_format", map[string]interface{}{"formats": "csv/xlsx/json"}))
+		return
+	}
+
+	err = models.EachUser(GetRequestDB(c), query, write)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		// csv/json 已开始输出, 出错时只能中断
+		if !c.Writer.Written() {
+			ResponseError(c, err)
+			return
+		}
+		_ = c.Error(err)
+	}
+}
@@ -0,0 +1,25 @@
+// Package dto 各接口的请求体, 通过 binding 标签声明校验规则, unique 标签声明唯一性检查
+// 请求体只包含客户端可以提交的字段, 不直接绑定到模型, 避免客户端修改 id/superuser 等字段
+package dto
+
+import "github.com/sulin2018/go-web-base/src/app/i18n"
+
+func setString(values map[string]interface{}, column string, value *string) {
+	if value != nil {
+		values[column] = *value
+	}
+}
+
+func setBool(values map[string]interface{}, column string, value *bool) {
+	if value != nil {
+		values[column] = *value
+	}
+}
+
+// normalizeLocale 转换为已加载的语言标识, 如 en-us 转换为 en-US, 空表示不指定
+func normalizeLocale(locale string) (string, bool) {
+	if locale == "" {
+		return "", true
+	}
+	return i18n.Supported(locale)
+}
@@ -0,0 +1,8 @@
+package dto
+
+// UploadCreate 创建分片上传任务, Hash 为整个文件的sha256, 为空时不校验
+type UploadCreate struct {
+	Name string `json:"name" binding:"required,max=255"`
+	Size int64  `json:"size" binding:"required,min=1"`
+	Hash string `json:"hash" binding:"omitempty,len=64,hexadecimal"`
+}
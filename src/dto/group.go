@@ -0,0 +1,62 @@
+package dto
+
+import "github.com/sulin2018/go-web-base/src/models"
+
+// GroupCreate 新增组
+type GroupCreate struct {
+	Name          string `json:"name" binding:"required,max=30" unique:"name"`
+	Description   string `json:"description" binding:"max=1000"`
+	UserIds       []uint `json:"user_ids" binding:"omitempty,dive,min=1"`
+	PermissionIds []uint `json:"permission_ids" binding:"omitempty,dive,min=1"`
+}
+
+func (GroupCreate) Model() interface{} {
+	return &models.Group{}
+}
+
+func (s *GroupCreate) Group() *models.Group {
+	return &models.Group{
+		Name:          s.Name,
+		Description:   s.Description,
+		UserIds:       s.UserIds,
+		PermissionIds: s.PermissionIds,
+	}
+}
+
+// GroupPatch 部分更新, 只修改请求中出现的字段
+type GroupPatch struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=30" unique:"name"`
+	Description *string `json:"description" binding:"omitempty,max=1000"`
+}
+
+func (GroupPatch) Model() interface{} {
+	return &models.Group{}
+}
+
+func (s *GroupPatch) Values() map[string]interface{} {
+	values := map[string]interface{}{}
+	setString(values, "name", s.Name)
+	setString(values, "description", s.Description)
+	return values
+}
+
+// GroupPut 全量更新, 关联id 为 null 时不修改
+type GroupPut struct {
+	Name          string `json:"name" binding:"required,max=30" unique:"name"`
+	Description   string `json:"description" binding:"max=1000"`
+	UserIds       []uint `json:"user_ids" binding:"omitempty,dive,min=1"`
+	PermissionIds []uint `json:"permission_ids" binding:"omitempty,dive,min=1"`
+}
+
+func (GroupPut) Model() interface{} {
+	return &models.Group{}
+}
+
+func (s *GroupPut) Values() map[string]interface{} {
+	return map[string]interface{}{"name": s.Name, "description": s.Description}
+}
+
+// BatchIds 批量操作的id
+type BatchIds struct {
+	Ids []uint `json:"ids" binding:"required,min=1,max=1000,dive,min=1"`
+}
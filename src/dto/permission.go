@@ -0,0 +1,43 @@
+package dto
+
+import "github.com/sulin2018/go-web-base/src/models"
+
+// PermissionCreate 新增权限, 名称在代码中通过 PermissionMiddleware 引用
+type PermissionCreate struct {
+	Name        string `json:"name" binding:"required,max=30,permission_name" unique:"name"`
+	Description string `json:"description" binding:"max=1000"`
+	GroupIds    []uint `json:"group_ids" binding:"omitempty,dive,min=1"`
+	UserIds     []uint `json:"user_ids" binding:"omitempty,dive,min=1"`
+}
+
+func (PermissionCreate) Model() interface{} {
+	return &models.Permission{}
+}
+
+func (s *PermissionCreate) Permission() *models.Permission {
+	return &models.Permission{
+		Name:        s.Name,
+		Description: s.Description,
+		GroupIds:    s.GroupIds,
+		UserIds:     s.UserIds,
+	}
+}
+
+// PermissionPatch 部分更新, 只修改请求中出现的字段, 关联id 为 null 时不修改
+type PermissionPatch struct {
+	Name        *string `json:"name" binding:"omitempty,max=30,permission_name" unique:"name"`
+	Description *string `json:"description" binding:"omitempty,max=1000"`
+	GroupIds    []uint  `json:"group_ids" binding:"omitempty,dive,min=1"`
+	UserIds     []uint  `json:"user_ids" binding:"omitempty,dive,min=1"`
+}
+
+func (PermissionPatch) Model() interface{} {
+	return &models.Permission{}
+}
+
+func (s *PermissionPatch) Values() map[string]interface{} {
+	values := map[string]interface{}{}
+	setString(values, "name", s.Name)
+	setString(values, "description", s.Description)
+	return values
+}
@@ -0,0 +1,127 @@
+package dto
+
+import (
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// 请求体只包含允许客户端修改的字段, id/version/created_at 等由服务端维护
+// 密码/头像通过各自的接口修改, superuser 只有超级管理员可以设置
+
+// UserCreate 新增用户, 密码为空时使用 UserBasePassword
+type UserCreate struct {
+	Username      string `json:"username" binding:"required,min=2,max=50,username" unique:"username"`
+	Password      string `json:"password" binding:"omitempty,password,max=72"`
+	ChineseName   string `json:"chinese_name" binding:"max=25"`
+	Phone         string `json:"phone" binding:"omitempty,phone"`
+	Active        *bool  `json:"active"`
+	Superuser     *bool  `json:"superuser"`
+	Locale        string `json:"locale" binding:"omitempty,locale"`
+	GroupIds      []uint `json:"group_ids" binding:"omitempty,dive,min=1"`
+	PermissionIds []uint `json:"permission_ids" binding:"omitempty,dive,min=1"`
+}
+
+func (UserCreate) Model() interface{} {
+	return &models.User{}
+}
+
+func (s *UserCreate) User() *models.User {
+	user := &models.User{
+		Username:      s.Username,
+		Password:      s.Password,
+		ChineseName:   s.ChineseName,
+		Phone:         s.Phone,
+		GroupIds:      s.GroupIds,
+		PermissionIds: s.PermissionIds,
+	}
+	user.Locale, _ = normalizeLocale(s.Locale)
+	if user.Password == "" {
+		user.Password = config.AppConfig.UserBasePassword
+	}
+	return user
+}
+
+// Values 创建后写入的字段, 布尔字段零值不会被 Create 写入
+func (s *UserCreate) Values() map[string]interface{} {
+	values := map[string]interface{}{}
+	setBool(values, "active", s.Active)
+	setBool(values, "superuser", s.Superuser)
+	return values
+}
+
+// UserPatch 部分更新, 只修改请求中出现的字段, false/空字符串同样会写入
+type UserPatch struct {
+	Username    *string `json:"username" binding:"omitempty,min=2,max=50,username" unique:"username"`
+	ChineseName *string `json:"chinese_name" binding:"omitempty,max=25"`
+	Phone       *string `json:"phone" binding:"omitempty,phone|len=0"`
+	Active      *bool   `json:"active"`
+	Superuser   *bool   `json:"superuser"`
+	Locale      *string `json:"locale" binding:"omitempty,locale|len=0"`
+}
+
+func (UserPatch) Model() interface{} {
+	return &models.User{}
+}
+
+func (s *UserPatch) Values() map[string]interface{} {
+	values := map[string]interface{}{}
+	setString(values, "username", s.Username)
+	setString(values, "chinese_name", s.ChineseName)
+	setString(values, "phone", s.Phone)
+	setBool(values, "active", s.Active)
+	setBool(values, "superuser", s.Superuser)
+	if s.Locale != nil {
+		values["locale"], _ = normalizeLocale(*s.Locale)
+	}
+	return values
+}
+
+// UserPut 全量更新, 未提交的字段置空; superuser/关联id 为 null 时不修改
+type UserPut struct {
+	Username      string `json:"username" binding:"required,min=2,max=50,username" unique:"username"`
+	ChineseName   string `json:"chinese_name" binding:"max=25"`
+	Phone         string `json:"phone" binding:"omitempty,phone"`
+	Active        *bool  `json:"active" binding:"required"`
+	Superuser     *bool  `json:"superuser"`
+	Locale        string `json:"locale" binding:"omitempty,locale"`
+	GroupIds      []uint `json:"group_ids" binding:"omitempty,dive,min=1"`
+	PermissionIds []uint `json:"permission_ids" binding:"omitempty,dive,min=1"`
+}
+
+func (UserPut) Model() interface{} {
+	return &models.User{}
+}
+
+func (s *UserPut) Values() map[string]interface{} {
+	values := map[string]interface{}{
+		"username":     s.Username,
+		"chinese_name": s.ChineseName,
+		"phone":        s.Phone,
+		"active":       *s.Active,
+	}
+	setBool(values, "superuser", s.Superuser)
+	values["locale"], _ = normalizeLocale(s.Locale)
+	return values
+}
+
+// UserLocale 设置自己的语言, 为空时按请求或默认语言
+type UserLocale struct {
+	Locale string `json:"locale" binding:"omitempty,locale"`
+}
+
+// PasswordChange 修改自己的密码
+type PasswordChange struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,password,max=72"`
+}
+
+// PasswordReset 管理员重置用户密码
+type PasswordReset struct {
+	Password string `json:"password" binding:"required,password,max=72"`
+}
+
+// UserLogin 登录
+type UserLogin struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
@@ -0,0 +1,151 @@
+package dto
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/i18n"
+	"github.com/sulin2018/go-web-base/src/app/openapi"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+var (
+	usernameRegexp       = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+	phoneRegexp          = regexp.MustCompile(`^\+?[0-9][0-9 -]{4,19}$`)
+	permissionNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// 自定义的校验规则, 在 binding 标签中使用, 如 binding:"omitempty,phone"
+var validations = map[string]validator.Func{
+	"username": func(fl validator.FieldLevel) bool {
+		return usernameRegexp.MatchString(fl.Field().String())
+	},
+	"phone": func(fl validator.FieldLevel) bool {
+		return phoneRegexp.MatchString(fl.Field().String())
+	},
+	"permission_name": func(fl validator.FieldLevel) bool {
+		return permissionNameRegexp.MatchString(fl.Field().String())
+	},
+	"password": func(fl validator.FieldLevel) bool {
+		return len(fl.Field().String()) >= models.PasswordMinLength
+	},
+	"locale": func(fl validator.FieldLevel) bool {
+		_, ok := i18n.Supported(fl.Field().String())
+		return ok
+	},
+}
+
+// 自定义规则提示中的参数
+var validationParams = map[string]func() map[string]interface{}{
+	"password": func() map[string]interface{} {
+		return map[string]interface{}{"min": models.PasswordMinLength}
+	},
+	"locale": func() map[string]interface{} {
+		return map[string]interface{}{"locales": strings.Join(i18n.Locales(), ", ")}
+	},
+}
+
+// SchemaRules 自定义规则在接口文档中的约束
+func SchemaRules() openapi.Rules {
+	pattern := func(re *regexp.Regexp) func(*openapi.Schema) {
+		return func(schema *openapi.Schema) {
+			schema.Pattern = re.String()
+		}
+	}
+	return openapi.Rules{
+		"username":        pattern(usernameRegexp),
+		"phone":           pattern(phoneRegexp),
+		"permission_name": pattern(permissionNameRegexp),
+		"password": func(schema *openapi.Schema) {
+			min := models.PasswordMinLength
+			schema.MinLength = &min
+		},
+		"locale": func(schema *openapi.Schema) {
+			schema.Description = "可选: " + strings.Join(i18n.Locales(), ", ")
+		},
+	}
+}
+
+// InitValidator 注册自定义规则, 校验错误中的字段名使用 json 名称
+func InitValidator() {
+	logrus.Trace("init validator")
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		logrus.Fatalln("unsupported validator engine")
+	}
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	for tag, fn := range validations {
+		if err := engine.RegisterValidation(tag, fn); err != nil {
+			logrus.Fatalln(err)
+		}
+	}
+	logrus.Trace("init validator complate")
+}
+
+// Target 需要检查唯一性的请求, Model 为对应的模型
+// 字段的 unique 标签为列名, 如 unique:"username", 值为空时不检查
+type Target interface {
+	Model() interface{}
+}
+
+// CheckUnique 检查 unique 标签的字段在数据库中是否已被使用, id 为当前记录, 新增时为0
+// skip 中的字段(已有其他错误)不检查
+func CheckUnique(tx *gorm.DB, req Target, id uint, skip map[string]bool) ([]apperr.FieldError, error) {
+	var details []apperr.FieldError
+	v := reflect.Indirect(reflect.ValueOf(req))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		column := t.Field(i).Tag.Get("unique")
+		name := strings.SplitN(t.Field(i).Tag.Get("json"), ",", 2)[0]
+		if column == "" || skip[name] {
+			continue
+		}
+		value := reflect.Indirect(v.Field(i))
+		if !value.IsValid() || value.IsZero() {
+			continue
+		}
+		taken, err := models.Taken(tx, req.Model(), column, value.Interface(), id)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			details = append(details, apperr.FieldError{Field: name, Code: "unique", Message: "already taken"})
+		}
+	}
+	return details, nil
+}
+
+// FieldErrors 转换为字段错误, 字段名为 json 路径, 如 group_ids[0]
+func FieldErrors(errs validator.ValidationErrors) []apperr.FieldError {
+	details := make([]apperr.FieldError, 0, len(errs))
+	for _, fieldErr := range errs {
+		field := fieldErr.Namespace()
+		// 去掉结构体名称, 如 UserCreate.group_ids[0]
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		var params map[string]interface{}
+		if fn, ok := validationParams[fieldErr.Tag()]; ok {
+			params = fn()
+		} else if fieldErr.Param() != "" {
+			params = map[string]interface{}{"param": fieldErr.Param()}
+		}
+		details = append(details, apperr.FieldError{Field: field, Code: fieldErr.Tag(), Message: fieldErr.Error(), Params: params})
+	}
+	return details
+}
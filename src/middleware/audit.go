@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+// 客户端可传入请求ID, 否则生成, 响应中返回
+const RequestIDHeader = "X-Request-ID"
+
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID, _ = utils.RandomHex(16)
+		}
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+func GetRequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+// RequestActor 当前登录用户及请求信息, 未登录时只有请求信息
+func RequestActor(c *gin.Context) *models.Actor {
+	actor := &models.Actor{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: GetRequestID(c),
+	}
+	if user := GetLoginUser(c); user != nil {
+		actor.ID, actor.Name = user.ID, user.Username
+	}
+	return actor
+}
+
+// Audit 记录安全审计事件, 操作人及请求信息从请求中获取
+// 写入失败只记录日志, 不影响请求
+func Audit(c *gin.Context, entry *models.AuditLog) {
+	entry.SetActor(RequestActor(c))
+	if err := models.AppendAudit(models.GetDB(models.RolePrimary), entry); err != nil {
+		logrus.Error("audit log error: ", entry.Event, " ", err)
+	}
+}
@@ -1,10 +1,10 @@
 package middleware
 
 import (
-	"net/http"
-
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 
+	"github.com/sulin2018/go-web-base/src/app/apperr"
 	"github.com/sulin2018/go-web-base/src/models"
 )
 
@@ -14,19 +14,42 @@ func GetLoginUser(c *gin.Context) *models.User {
 		return nil
 	}
 
-	user := models.User{Username: username.(string)}
-	err = models.Detail(&user)
-	if err != nil {
+	var user models.User
+	err = models.Detail(&user, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("username = ?", username)
+	})
+	if err != nil || user.ID == 0 {
 		return nil
 	}
 
+	// 会话已被撤销
+	if user.SessionsRevokedAt != nil {
+		loginAt, _ := GetSession(c, "login_at")
+		if at, ok := loginAt.(int64); !ok || at <= user.SessionsRevokedAt.Unix() {
+			return nil
+		}
+	}
+
 	return &user
 }
 
+// auditDenied 记录无权限的请求
+func auditDenied(c *gin.Context, required string) {
+	entry := &models.AuditLog{
+		Event:      models.AuditPermissionDeny,
+		Outcome:    models.AuditDenied,
+		TargetType: "route",
+		TargetName: c.Request.Method + " " + c.Request.URL.Path,
+	}
+	entry.SetDetail(map[string]string{"required": required})
+	Audit(c, entry)
+}
+
 func LoginPermissionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if GetLoginUser(c) == nil {
-			c.AbortWithStatus(http.StatusForbidden)
+			RenderError(c, apperr.ErrUnauthorized)
+			return
 		}
 
 		// before request
@@ -35,10 +58,25 @@ func LoginPermissionMiddleware() gin.HandlerFunc {
 	}
 }
 
+func SuperuserMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetLoginUser(c)
+		if user == nil || !user.Superuser {
+			auditDenied(c, "superuser")
+			RenderError(c, apperr.ErrForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
 func PermissionMiddleware(permName string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !CheckPermission(c, permName) {
-			c.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{"code": http.StatusForbidden, "message": "无权限"})
+			auditDenied(c, permName)
+			RenderError(c, apperr.ErrForbidden)
+			return
 		}
 
 		// before request
@@ -57,14 +95,14 @@ func CheckPermission(c *gin.Context, permName string) bool {
 		return true
 	}
 
+	// 已软删除的权限查询不到, 视为无权限
 	tempPerm := &models.Permission{Name: permName}
-	err := models.LoadColumns(tempPerm, []string{"id"})
-	if err != nil {
+	if !models.Exist(tempPerm) {
 		return false
 	}
 
 	// 先从用户关联权限寻找
-	err = user.LoadPermAssociationIds()
+	err := user.LoadPermAssociationIds()
 	if err != nil {
 		return false
 	}
@@ -83,13 +121,19 @@ func CheckPermission(c *gin.Context, permName string) bool {
 	if err != nil {
 		return false
 	}
+	var commonGroupIds []uint
 	for _, userGroupId := range user.GroupIds {
 		for _, permGroupId := range tempPerm.GroupIds {
 			if userGroupId == permGroupId {
-				return true
+				commonGroupIds = append(commonGroupIds, userGroupId)
 			}
 		}
 	}
+	if len(commonGroupIds) == 0 {
+		return false
+	}
 
-	return false
+	// 已软删除的组不再授予权限
+	count, err := models.CountExist(&models.Group{}, commonGroupIds)
+	return err == nil && count > 0
 }
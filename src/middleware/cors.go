@@ -25,8 +25,9 @@ func CorsMiddleware() gin.HandlerFunc {
 		if isAccess {
 			c.Header("Access-Control-Allow-Origin", origin)
 			c.Header("Access-Control-Allow-Credentials", "true")
-			c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, Accept, Origin, Cache-Control")
-			c.Header("Access-Control-Allow-Methods", "GET, OPTIONS, POST, PUT, DELETE")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, Accept, Origin, Cache-Control, If-Match, Upload-Offset, Upload-Checksum, X-Request-ID")
+			c.Header("Access-Control-Expose-Headers", "ETag, Upload-Offset, X-Request-ID")
+			c.Header("Access-Control-Allow-Methods", "GET, OPTIONS, POST, PUT, PATCH, DELETE")
 			c.Header("Access-Control-Max-Age", "172800")
 			c.Set("content-type", "application/json")
 		}
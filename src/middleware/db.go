@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// 写操作后设置, 有效期内该客户端的读请求使用主库
+const dbStickyCookie = "db_sticky"
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// DBRoleMiddleware 按请求选择数据库连接
+// 读请求使用从库; 写请求及写请求后 DBStickyWindow 内的读请求使用主库
+func DBRoleMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := models.RolePrimary
+		if isReadMethod(c.Request.Method) {
+			if _, err := c.Cookie(dbStickyCookie); err != nil {
+				role = models.RoleReplica
+			}
+		} else if config.AppConfig.DBStickyWindow > 0 {
+			// 响应写出后不能再设置cookie, 在处理前设置
+			c.SetCookie(dbStickyCookie, "1", config.AppConfig.DBStickyWindow, "/", "", false, true)
+		}
+		tx := models.GetDB(role)
+		if role == models.RolePrimary && !isReadMethod(c.Request.Method) {
+			// 写请求的变更历史记录操作人
+			tx = models.WithActor(tx, RequestActor(c))
+		}
+		c.Set("db", tx)
+		c.Next()
+	}
+}
+
+// GetRequestDB 获取当前请求使用的数据库连接
+func GetRequestDB(c *gin.Context) *gorm.DB {
+	if tx, ok := c.Get("db"); ok {
+		return tx.(*gorm.DB)
+	}
+	return models.GetDB(models.RolePrimary)
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/avatar"
+	"github.com/sulin2018/go-web-base/src/app/storage"
+	"github.com/sulin2018/go-web-base/src/app/upload"
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// 业务层错误对应的接口错误, 按顺序匹配
+var errorMapping = []struct {
+	err    error
+	appErr *apperr.AppError
+}{
+	{gorm.ErrRecordNotFound, apperr.ErrNotFound},
+	{models.ErrRevisionNotFound, apperr.ErrNotFound},
+	{models.ErrNotDeleted, apperr.ErrNotDeleted},
+	{models.ErrVersionConflict, apperr.ErrVersionConflict},
+	{models.ErrUsernameTaken, apperr.ErrUsernameTaken},
+	{storage.ErrNotFound, apperr.ErrNotFound},
+	{storage.ErrInvalidKey, apperr.ErrInvalidParam},
+	{storage.ErrUnsupported, apperr.ErrNotImplemented},
+	{upload.ErrTooLarge, apperr.ErrTooLarge},
+	{upload.ErrChunkTooLarge, apperr.ErrTooLarge},
+	{upload.ErrOffsetMismatch, apperr.New(http.StatusConflict, "upload_offset_mismatch", "上传位置不一致")},
+	{upload.ErrChecksum, apperr.New(http.StatusBadRequest, "upload_checksum_mismatch", "分片校验失败")},
+	{upload.ErrExpired, apperr.New(http.StatusGone, "upload_expired", "上传已过期")},
+	{upload.ErrCompleted, apperr.New(http.StatusConflict, "upload_completed", "上传已完成")},
+	{avatar.ErrTooLarge, apperr.ErrTooLarge},
+	{avatar.ErrExtNotAllow, apperr.New(http.StatusBadRequest, "avatar_ext_not_allowed", "不支持的头像文件类型")},
+	{avatar.ErrTypeNotAllow, apperr.New(http.StatusBadRequest, "avatar_type_not_allowed", "不支持的头像文件类型")},
+	{avatar.ErrBadImage, apperr.New(http.StatusBadRequest, "avatar_bad_image", "头像图片无效")},
+}
+
+// ToAppError 将错误转换为 AppError, 原错误作为 Cause
+// 数据库的唯一约束/外键错误分别为 409 duplicate/referenced, 未知错误为 500, 原始信息不返回给客户端
+func ToAppError(err error) *apperr.AppError {
+	var appErr *apperr.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	for _, m := range errorMapping {
+		if errors.Is(err, m.err) {
+			return m.appErr.WithCause(err)
+		}
+	}
+	switch {
+	case errors.Is(err, models.ErrInvalidQuery):
+		// 白名单校验的提示, 不含数据库信息
+		reason := strings.TrimPrefix(err.Error(), models.ErrInvalidQuery.Error()+": ")
+		return apperr.ErrInvalidQuery.WithKey("error.invalid_query_reason", map[string]interface{}{"reason": reason}).WithCause(err)
+	case models.IsDuplicateError(err):
+		return apperr.ErrDuplicate.WithCause(err)
+	case models.IsForeignKeyError(err):
+		return apperr.ErrReferenced.WithCause(err)
+	}
+	return apperr.ErrInternal.WithCause(err)
+}
+
+// RenderError 输出错误并中止后续处理
+// 保留原有的 code(HTTP状态码)/message/data 字段, error_code 为稳定的错误码
+// message 按请求的语言翻译, message_key/params 为消息标识及参数, 客户端可自行翻译
+func RenderError(c *gin.Context, err *apperr.AppError) {
+	RenderErrorData(c, err, nil)
+}
+
+// RenderErrorData 同 RenderError, data 为错误附带的数据, 如版本冲突时的当前记录
+func RenderErrorData(c *gin.Context, err *apperr.AppError, data interface{}) {
+	body := map[string]interface{}{
+		"code":        err.Status,
+		"message":     T(c, err.MessageKey(), err.Params, err.Message),
+		"message_key": err.MessageKey(),
+		"data":        data,
+		"error_code":  err.Code,
+		"request_id":  GetRequestID(c),
+	}
+	if len(err.Params) != 0 {
+		body["params"] = err.Params
+	}
+	if len(err.Details) != 0 {
+		LocalizeFieldErrors(c, err.Details)
+		body["details"] = err.Details
+	}
+	c.AbortWithStatusJSON(err.Status, body)
+}
+
+// ErrorMiddleware 统一输出处理函数通过 c.Error 记录的错误, 以最后一个为准
+// 响应已开始输出(如导出中途出错)时只记录日志
+func ErrorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 {
+			return
+		}
+		appErr := ToAppError(c.Errors.Last().Err)
+		if appErr.Status >= 500 {
+			logrus.Errorf("%s %s [%s]: %v", c.Request.Method, c.Request.URL.Path, GetRequestID(c), appErr)
+		} else if appErr.Cause != nil {
+			logrus.Warnf("%s %s [%s]: %v", c.Request.Method, c.Request.URL.Path, GetRequestID(c), appErr)
+		}
+		if c.Writer.Written() {
+			return
+		}
+		RenderError(c, appErr)
+	}
+}
+
+// RecoveryHandler panic 时返回 500, 不暴露 panic 信息
+func RecoveryHandler(c *gin.Context, recovered interface{}) {
+	RenderError(c, apperr.ErrInternal.WithCause(fmt.Errorf("panic: %v", recovered)))
+}
+
+// NoRouteHandler 未注册的路由
+func NoRouteHandler(c *gin.Context) {
+	RenderError(c, apperr.ErrRouteNotFound)
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/i18n"
+)
+
+const localeKey = "locale"
+
+// GetLocale 当前请求的语言: Accept-Language 中支持的语言, 其次为登录用户设置的语言, 最后为默认语言
+func GetLocale(c *gin.Context) string {
+	if locale := c.GetString(localeKey); locale != "" {
+		return locale
+	}
+	locale := i18n.MatchAcceptLanguage(c.GetHeader("Accept-Language"))
+	if locale == "" {
+		if user := GetLoginUser(c); user != nil {
+			locale, _ = i18n.Supported(user.Locale)
+		}
+	}
+	if locale == "" {
+		locale = i18n.DefaultLocale()
+	}
+	c.Set(localeKey, locale)
+	c.Header("Content-Language", locale)
+	return locale
+}
+
+// T 按当前请求的语言翻译, 消息目录中没有时返回 fallback
+func T(c *gin.Context, key string, params map[string]interface{}, fallback string) string {
+	if message, ok := i18n.T(GetLocale(c), key, params); ok {
+		return message
+	}
+	return i18n.Format(fallback, params)
+}
+
+// LocalizeFieldErrors 翻译字段错误的提示
+func LocalizeFieldErrors(c *gin.Context, details []apperr.FieldError) {
+	for i := range details {
+		details[i].Message = T(c, details[i].MessageKey(), details[i].Params, details[i].Message)
+	}
+}
@@ -9,8 +9,9 @@ import (
 
 func AppLogger() gin.HandlerFunc {
 	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		return fmt.Sprintf("%s %s %s %s %s %d %s %s %s \n",
+		return fmt.Sprintf("%s %s %s %s %s %s %d %s %s %s \n",
 			param.TimeStamp.Format(utils.TIMEFORMAT),
+			param.Keys["request_id"],
 			param.ClientIP,
 			param.Method,
 			param.Path,
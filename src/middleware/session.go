@@ -16,7 +16,7 @@ var store *gormstore.Store
 
 func InitSessionStore() {
 	if store == nil {
-		store = gormstore.New(models.GetDB(), []byte(config.AppConfig.AppSecret))
+		store = gormstore.New(models.GetDB(models.RolePrimary), []byte(config.AppConfig.AppSecret))
 		// db cleanup every hour
 		// close quit channel to stop cleanup
 		quit := make(chan struct{})
@@ -80,3 +80,23 @@ func SetSession(c *gin.Context, key string, value interface{}) error {
 	}
 	return nil
 }
+
+// ClearSession 删除当前会话, 用于退出登录
+func ClearSession(c *gin.Context) error {
+	tempValue, exists := c.Get("session")
+	if !exists {
+		logrus.Error("获取sessions有误")
+		return errors.New("session not exist")
+	}
+	cSession := tempValue.(*sessions.Session)
+
+	cSession.Values = map[interface{}]interface{}{}
+	cSession.Options.MaxAge = -1
+	err := cSession.Save(c.Request, c.Writer)
+	if err != nil {
+		logrus.Error("设置session有误")
+		logrus.Error(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// 审计事件
+const (
+	AuditLoginSuccess    = "login_success"
+	AuditLoginFailure    = "login_failure"
+	AuditLogout          = "logout"
+	AuditPasswordChange  = "password_change"
+	AuditPermissionDeny  = "permission_denied"
+	AuditPrivilegeGrant  = "privilege_grant"
+	AuditPrivilegeRevoke = "privilege_revoke"
+	AuditSessionRevoke   = "session_revoke"
+)
+
+// 审计结果
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// Actor 操作人及请求信息, 记录到变更历史和审计日志
+type Actor struct {
+	ID        uint
+	Name      string
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// AuditLog 安全审计日志, 只追加不修改
+// 每条记录的 Hash 包含上一条的 Hash, 修改或删除中间的记录后链会断开, 见 VerifyAudit
+type AuditLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	Event      string    `gorm:"type:varchar(30);not null;index" json:"event"`
+	Outcome    string    `gorm:"type:varchar(20);not null" json:"outcome"`
+	ActorID    uint      `gorm:"index" description:"操作人, 0表示未登录或系统" json:"actor_id"`
+	ActorName  string    `gorm:"type:varchar(50)" json:"actor_name"`
+	IP         string    `gorm:"type:varchar(45)" json:"ip"`
+	UserAgent  string    `gorm:"type:varchar(255)" json:"user_agent"`
+	RequestID  string    `gorm:"type:varchar(64)" json:"request_id"`
+	TargetType string    `gorm:"type:varchar(20)" description:"对象类型, 如 user/group/permission/route" json:"target_type"`
+	TargetID   uint      `json:"target_id"`
+	TargetName string    `gorm:"type:varchar(255)" description:"对象名称, 如用户名/请求路径" json:"target_name"`
+	Detail     JSONText  `gorm:"type:text" json:"detail"`
+	PrevHash   string    `gorm:"type:char(64);not null" json:"prev_hash"`
+	Hash       string    `gorm:"type:char(64);not null" json:"hash"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
+
+// AuditHead 审计日志链的最新 Hash, 只有一行
+// 追加时先锁定该行, 多个实例写入时也能保证链的顺序; 与最后一条记录比较可发现末尾被删除
+type AuditHead struct {
+	ID        uint   `gorm:"primaryKey"`
+	Hash      string `gorm:"type:char(64);not null"`
+	Count     uint   `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (AuditHead) TableName() string {
+	return "audit_head"
+}
+
+// AuditVerify 校验结果, Valid 为 false 时 BrokenID 为第一条不一致的记录, 0表示末尾的记录被删除
+type AuditVerify struct {
+	Count    uint `json:"count"`
+	Valid    bool `json:"valid"`
+	BrokenID uint `json:"broken_id"`
+}
+
+var AuditQueryFields = QueryFields{
+	Search:      []string{"actor_name", "target_name", "ip"},
+	Filter:      []string{"id", "event", "outcome", "actor_id", "target_type", "target_id", "ip", "request_id", "created_at"},
+	Sort:        []string{"id", "created_at"},
+	DefaultSort: "-id",
+}
+
+// SetActor 设置操作人及请求信息, actor 为 nil 时不设置
+func (s *AuditLog) SetActor(actor *Actor) {
+	if actor == nil {
+		return
+	}
+	s.ActorID, s.ActorName = actor.ID, actor.Name
+	s.IP, s.UserAgent, s.RequestID = actor.IP, actor.UserAgent, actor.RequestID
+	if len(s.UserAgent) > 255 {
+		s.UserAgent = s.UserAgent[:255]
+	}
+}
+
+// SetDetail 以JSON保存事件详情
+func (s *AuditLog) SetDetail(detail interface{}) {
+	raw, err := json.Marshal(detail)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	s.Detail = JSONText(raw)
+}
+
+// computeHash 计算记录的 Hash, 不包括数据库生成的 id
+// 时间精确到秒, 各数据库读回的值一致
+func (s *AuditLog) computeHash() string {
+	content, _ := json.Marshal([]interface{}{
+		s.PrevHash, s.CreatedAt.UTC().Format(time.RFC3339), s.Event, s.Outcome,
+		s.ActorID, s.ActorName, s.IP, s.UserAgent, s.RequestID,
+		s.TargetType, s.TargetID, s.TargetName, string(s.Detail),
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// AppendAudit 追加审计日志, tx 在事务中时随事务提交或回滚
+func AppendAudit(tx *gorm.DB, entry *AuditLog) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		// 锁定链头, 同一时间只有一个写入
+		result := tx.Model(&AuditHead{ID: 1}).UpdateColumn("count", gorm.Expr("count + ?", 1))
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+		var head AuditHead
+		if result = tx.First(&head, 1); result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+
+		entry.ID = 0
+		entry.CreatedAt = time.Now().Truncate(time.Second)
+		entry.PrevHash = head.Hash
+		entry.Hash = entry.computeHash()
+		if result = tx.Create(entry); result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+		result = tx.Model(&head).Updates(map[string]interface{}{"hash": entry.Hash, "updated_at": entry.CreatedAt})
+		if result.Error != nil {
+			logrus.Error(result.Error)
+		}
+		return result.Error
+	})
+}
+
+// EachAudit 按 id 顺序遍历审计日志, 逐行读取, 用于导出和校验
+func EachAudit(tx *gorm.DB, fn func(entry *AuditLog) error, scopes ...func(*gorm.DB) *gorm.DB) error {
+	rows, err := tx.Model(&AuditLog{}).Scopes(scopes...).Order("id").Rows()
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditLog
+		if err = tx.ScanRows(rows, &entry); err != nil {
+			logrus.Error(err)
+			return err
+		}
+		if err = fn(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportAudit 按 query 过滤后遍历审计日志, query 需经过 AuditQueryFields 校验
+func ExportAudit(tx *gorm.DB, query *ListQuery, fn func(entry *AuditLog) error) error {
+	if err := AuditQueryFields.Check(query); err != nil {
+		return err
+	}
+	var scopes []func(*gorm.DB) *gorm.DB
+	if query.Search != "" {
+		scopes = append(scopes, DBSearch(AuditQueryFields.Search, query.Search))
+	}
+	if len(query.Filters) != 0 {
+		scopes = append(scopes, DBFilter(query.Filters))
+	}
+	return EachAudit(tx, fn, scopes...)
+}
+
+// VerifyAudit 从头校验 Hash 链
+func VerifyAudit(tx *gorm.DB) (*AuditVerify, error) {
+	verify := &AuditVerify{Valid: true}
+	prevHash := ""
+	err := EachAudit(tx, func(entry *AuditLog) error {
+		verify.Count++
+		if verify.Valid && (entry.PrevHash != prevHash || entry.computeHash() != entry.Hash) {
+			verify.Valid, verify.BrokenID = false, entry.ID
+		}
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if verify.Valid {
+		var head AuditHead
+		if result := tx.First(&head, 1); result.Error != nil {
+			logrus.Error(result.Error)
+			return nil, result.Error
+		}
+		if head.Hash != prevHash || head.Count != verify.Count {
+			verify.Valid = false
+		}
+	}
+	return verify, nil
+}
+
+// auditPrivilege 由变更历史的前后快照生成授予/撤销权限的审计日志
+// 关联关系(组成员/组权限/用户权限)及超级用户的变化均视为授权变化
+func auditPrivilege(tx *gorm.DB, actor *Actor, key historyKey, before, after map[string]interface{}) error {
+	granted := map[string]interface{}{}
+	revoked := map[string]interface{}{}
+	for _, assoc := range historyTypes[key.recordType].assocs {
+		added, removed := diffIds(snapshotIds(before, assoc.key), snapshotIds(after, assoc.key))
+		if len(added) != 0 {
+			granted[assoc.key] = added
+		}
+		if len(removed) != 0 {
+			revoked[assoc.key] = removed
+		}
+	}
+	if key.recordType == HistoryUser {
+		wasSuperuser, isSuperuser := before["superuser"] == true, after["superuser"] == true
+		if isSuperuser && !wasSuperuser {
+			granted["superuser"] = true
+		}
+		if wasSuperuser && !isSuperuser {
+			revoked["superuser"] = true
+		}
+	}
+
+	snapshot := after
+	if snapshot == nil {
+		snapshot = before
+	}
+	name, _ := snapshot["name"].(string)
+	if key.recordType == HistoryUser {
+		name, _ = snapshot["username"].(string)
+	}
+
+	events := []string{AuditPrivilegeGrant, AuditPrivilegeRevoke}
+	for i, detail := range []map[string]interface{}{granted, revoked} {
+		if len(detail) == 0 {
+			continue
+		}
+		entry := &AuditLog{Event: events[i], Outcome: AuditSuccess, TargetType: key.recordType, TargetID: key.id, TargetName: name}
+		entry.SetActor(actor)
+		entry.SetDetail(detail)
+		if err := AppendAudit(tx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,8 @@
 package models
 
 import (
-	"fmt"
-	"strings"
+	"errors"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 	"github.com/sulin2018/go-web-base/src/app/config"
@@ -12,72 +10,76 @@ import (
 
 var db *gorm.DB
 
+var (
+	ErrNotDeleted      = errors.New("record not deleted")
+	ErrVersionConflict = errors.New("record version conflict")
+)
+
 func DBInit() {
 	logrus.Trace("db init")
 	var err error
-	db, err = gorm.Open(config.AppConfig.DBType,
-		fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
-			config.AppConfig.DBUser,
-			config.AppConfig.DBPassword,
-			config.AppConfig.DBHost,
-			config.AppConfig.DBDatabase),
-	)
+	db, err = connectWithRetry(config.AppConfig.DBHost)
 	if err != nil {
 		logrus.Panicln("models.Setup err: ", err)
 	}
+	primaryHealth.set(nil)
+	initReplicas()
+	go runPinger()
+	logrus.Trace("db init complate")
+}
+
+// openDB 连接主库或从库
+func openDB(host string) (*gorm.DB, error) {
+	dsn, err := DSN(host)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := gorm.Open(config.AppConfig.DBType, dsn)
+	if err != nil {
+		return nil, err
+	}
 
 	// Disable table name's pluralization
-	db.SingularTable(true)
-	db.DB().SetMaxIdleConns(10)
-	db.DB().SetMaxOpenConns(100)
+	conn.SingularTable(true)
+	setPool(conn.DB())
 
 	// Disable association auto update/create
-	// db.InstantSet("gorm:association_autoupdate", false)
-	// db.InstantSet("gorm:association_autocreate", false)
+	// 关联常以只有ID的结构体传入(如 GroupIds), 自动更新会把关联记录的其他字段覆盖为空值
+	conn.InstantSet("gorm:association_autoupdate", false)
+	// conn.InstantSet("gorm:association_autocreate", false)
+
+	registerHistoryCallbacks(conn)
 
 	if config.AppConfig.AppRunMode == "dev" {
-		db.LogMode(true)
+		conn.LogMode(true)
 	}
-
-	DBAutoMigrate()
-	InitAuth()
-	logrus.Trace("db init complate")
-}
-
-func DBAutoMigrate() {
-	db.AutoMigrate(
-		&User{},
-		&Permission{},
-		&Group{},
-	)
+	return conn, nil
 }
 
+// InitAuth 写入权限系统必需的数据, 每次启动执行, 已存在时不重复创建
+// 其他初始化数据见 SeedPath 目录
 func InitAuth() {
-	// 创建默认管理员
-	admin := User{Username: "admin"}
-	if !Exist(&admin) {
-		admin.Password = config.AppConfig.UserBasePassword
-		_ = admin.EncryptPassword()
-		admin.Superuser = true
-
-		err := admin.Create()
-		if err != nil {
-			logrus.Error("权限系统初始化失败")
-			logrus.Error(err)
-		}
-
-		group := Group{Name: "administrator", Description: "website administrator group"}
-		perm := Permission{Name: "manage_user", Description: "manage user/group/permission permission, create/delete/edit/query", Groups: []*Group{&group}}
-		err = perm.Create()
-		if err != nil {
-			logrus.Error("权限系统初始化失败")
-			logrus.Error(err)
-		}
+	if err := ApplySeed(authSeed()); err != nil {
+		logrus.Error("权限系统初始化失败")
+		logrus.Error(err)
 	}
 }
 
-func GetDB() *gorm.DB {
-	return db
+// authSeed 权限系统必需的权限/组/超级管理员
+func authSeed() *Seed {
+	superuser := true
+	return &Seed{
+		Permissions: []SeedPermission{
+			{Name: "manage_user", Description: "manage user/group/permission permission, create/delete/edit/query"},
+			{Name: "manage_file", Description: "manage all users' files, download/delete"},
+		},
+		Groups: []SeedGroup{
+			{Name: "administrator", Description: "website administrator group", Permissions: []string{"manage_user", "manage_file"}},
+		},
+		Users: []SeedUser{
+			{Username: "admin", Superuser: &superuser, Groups: []string{"administrator"}},
+		},
+	}
 }
 
 func DBPage(page uint, pageSize uint) func(db *gorm.DB) *gorm.DB {
@@ -87,65 +89,169 @@ func DBPage(page uint, pageSize uint) func(db *gorm.DB) *gorm.DB {
 	}
 }
 
-func DBSearch(searchMap map[string]string) func(db *gorm.DB) *gorm.DB {
+// DBSelect 限定查询字段
+func DBSelect(col string) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		var searchQS []string
-		var args []interface{}
-		for k, v := range searchMap {
-			searchQS = append(searchQS, fmt.Sprintf("%s LIKE ?", k))
-			args = append(args, "%"+v+"%")
-		}
-		qs := strings.Join(searchQS, " OR ")
-		//logrus.Println(qs)
-		return db.Where(qs, args...)
+		return db.Select(col)
 	}
 }
 
-func DBFilter(filterMap map[string]interface{}) func(db *gorm.DB) *gorm.DB {
+// DBDeleted 只查询已软删除的记录
+func DBDeleted() func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		var filters []string
-		var args []interface{}
-		for k, v := range filterMap {
-			filters = append(filters, fmt.Sprintf("%s = ?", k))
-			args = append(args, v)
-		}
-		qs := strings.Join(filters, " AND ")
-		//logrus.Println(qs)
-		return db.Where(qs, args...)
+		return db.Unscoped().Where("deleted_at IS NOT NULL")
 	}
 }
 
-func DBOrder(orderCols []string) func(db *gorm.DB) *gorm.DB {
-	return func(db *gorm.DB) *gorm.DB {
-		qs := strings.Join(orderCols, ",")
-		//logrus.Println(qs)
-		return db.Order(qs)
+func LoadColumns(tempModel interface{}, columns []string) error {
+	result := db.Select(columns).Find(tempModel)
+	if result.Error != nil {
+		logrus.Error(result.Error)
 	}
+	return result.Error
 }
 
-func LoadColumns(tempModel interface{}, columns []string) error {
-	result := db.Select(columns).Find(tempModel)
+// scopes 附加查询条件, 如 QueryFields.DBSparse
+func Detail(tempModel interface{}, scopes ...func(*gorm.DB) *gorm.DB) error {
+	return DetailFrom(db, tempModel, scopes...)
+}
+
+// DetailFrom 从指定连接查询, 如 GetDB(RoleReplica)
+func DetailFrom(tx *gorm.DB, tempModel interface{}, scopes ...func(*gorm.DB) *gorm.DB) error {
+	result := tx.Scopes(scopes...).Find(tempModel)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+	}
+	return result.Error
+}
+
+// WithTx 在同一个事务中执行 fn, fn 返回错误或 panic 时回滚
+// 模型的多步写操作均提供 xxxTx(tx) 版本, 可在 fn 中组合使用
+func WithTx(fn func(tx *gorm.DB) error) error {
+	return WithTxFrom(db, fn)
+}
+
+// WithTxFrom 在指定连接上执行事务, 如携带操作人的 GetRequestDB(c)
+// 事务中修改的用户/组/权限在提交前写入变更历史
+func WithTxFrom(conn *gorm.DB, fn func(tx *gorm.DB) error) error {
+	// 已在事务中时直接执行, 由外层事务写入历史
+	if _, ok := conn.Get(historyChangesKey); ok {
+		return fn(conn)
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		changes := newHistoryChanges(tx)
+		tx = tx.Set(historyChangesKey, changes)
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return changes.flush(tx)
+	})
+}
+
+// loadDeleted 按主键获取已软删除的记录
+func loadDeleted(tx *gorm.DB, tempModel interface{}) error {
+	result := tx.Unscoped().Where("deleted_at IS NOT NULL").First(tempModel)
+	if result.RecordNotFound() {
+		return ErrNotDeleted
+	}
 	if result.Error != nil {
 		logrus.Error(result.Error)
 	}
 	return result.Error
 }
 
-func Detail(tempModel interface{}) error {
-	result := db.Find(tempModel)
+// Restore 恢复软删除的记录, 软删除时未清除关联关系, 恢复后关联随之恢复
+func Restore(tempModel interface{}) error {
+	return WithTx(func(tx *gorm.DB) error {
+		return RestoreTx(tx, tempModel)
+	})
+}
+
+func RestoreTx(tx *gorm.DB, tempModel interface{}) error {
+	if err := loadDeleted(tx, tempModel); err != nil {
+		return err
+	}
+	result := tx.Unscoped().Model(tempModel).Update("deleted_at", nil)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 	}
 	return result.Error
 }
 
+// CountExist 统计 ids 中未删除的记录数
+func CountExist(tempModel interface{}, ids []uint) (uint, error) {
+	var count uint
+	result := db.Model(tempModel).Where("id IN (?)", ids).Count(&count)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+	}
+	return count, result.Error
+}
+
 func Exist(tempModel interface{}) bool {
 	result := db.Select("id").Find(tempModel, tempModel)
 	return result.RowsAffected == 1
 }
 
-func UpdateByMapOrStruct(tempModel interface{}, nowDatas interface{}) error {
-	result := db.Model(tempModel).Updates(nowDatas)
+// UpdateByMapOrStruct 更新成功后版本加一
+// version 不为0时仅当记录当前版本与之相同才更新, 否则返回 ErrVersionConflict
+// 记录不存在或已删除时返回 gorm.ErrRecordNotFound
+func UpdateByMapOrStruct(tempModel interface{}, nowDatas interface{}, version uint) error {
+	return WithTx(func(tx *gorm.DB) error {
+		return UpdateByMapOrStructTx(tx, tempModel, nowDatas, version)
+	})
+}
+
+func UpdateByMapOrStructTx(tx *gorm.DB, tempModel interface{}, nowDatas interface{}, version uint) error {
+	if err := bumpVersion(tx, tempModel, version); err != nil {
+		return err
+	}
+	result := tx.Model(tempModel).Omit("version").Updates(nowDatas)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+	}
+	return result.Error
+}
+
+// Taken 字段值是否已被其他记录使用, 包括已删除的记录, excludeID 为当前记录
+func Taken(tx *gorm.DB, model interface{}, column string, value interface{}, excludeID uint) (bool, error) {
+	query := tx.Unscoped().Model(model).Where(tx.Dialect().Quote(column)+" = ?", value)
+	if excludeID != 0 {
+		query = query.Where("id <> ?", excludeID)
+	}
+	var count uint
+	if err := query.Count(&count).Error; err != nil {
+		logrus.Error(err)
+		return false, err
+	}
+	return count != 0, nil
+}
+
+// bumpVersion 乐观锁, 校验并递增版本, 新版本写回 tempModel
+// version 为0时不校验; 记录不存在时返回 gorm.ErrRecordNotFound, 版本不一致时返回 ErrVersionConflict
+func bumpVersion(tx *gorm.DB, tempModel interface{}, version uint) error {
+	query := tx.Model(tempModel)
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.UpdateColumn("version", gorm.Expr("version + ?", 1))
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var count int
+		if err := tx.Model(tempModel).Count(&count).Error; err != nil {
+			logrus.Error(err)
+			return err
+		}
+		if count == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return ErrVersionConflict
+	}
+
+	result = tx.Select("version").First(tempModel)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 	}
@@ -156,8 +262,9 @@ func UpdateByMapOrStruct(tempModel interface{}, nowDatas interface{}) error {
 // count 总数指针
 // page 页码, 0表示获取所有/不分页
 // pageSize 页大小, 0表示使用配置大小
-func Page(results interface{}, count interface{}, page uint, pageSize uint) error {
-	tempQuery := db
+// scopes 附加查询条件, 如 DBDeleted()
+func Page(results interface{}, count interface{}, page uint, pageSize uint, scopes ...func(*gorm.DB) *gorm.DB) error {
+	tempQuery := db.Scopes(scopes...)
 	tempQuery.Model(results).Count(count)
 
 	if page != 0 {
@@ -175,8 +282,9 @@ func Page(results interface{}, count interface{}, page uint, pageSize uint) erro
 }
 
 // 分页获取 限定字段
-func PageColumns(results interface{}, count interface{}, page uint, pageSize uint, col string) error {
-	tempQuery := db
+// scopes 附加查询条件, 如 DBDeleted()
+func PageColumns(results interface{}, count interface{}, page uint, pageSize uint, col string, scopes ...func(*gorm.DB) *gorm.DB) error {
+	tempQuery := db.Scopes(scopes...)
 	tempQuery.Model(results).Count(count)
 
 	if page != 0 {
@@ -193,15 +301,21 @@ func PageColumns(results interface{}, count interface{}, page uint, pageSize uin
 	return nil
 }
 
-func ListPageSearchFilterOrder(results interface{}, count interface{}, page uint, pageSize uint, searchMap map[string]string, filterMap map[string]interface{}, orderCols []string) error {
-	tempQuery := db
+// ListPageSearchFilterOrder 按 query 搜索/过滤/排序后分页
+// tx 为查询使用的连接, 如 GetDB(RoleReplica)
+// query 中的字段先经过 fields 白名单校验, 不合法时返回 ErrInvalidQuery
+func ListPageSearchFilterOrder(tx *gorm.DB, results interface{}, count interface{}, page uint, pageSize uint, fields QueryFields, query *ListQuery, scopes ...func(*gorm.DB) *gorm.DB) error {
+	if err := fields.Check(query); err != nil {
+		return err
+	}
+	tempQuery := tx.Scopes(scopes...)
 
 	// filter search
-	if searchMap != nil {
-		tempQuery = tempQuery.Scopes(DBSearch(searchMap))
+	if query.Search != "" {
+		tempQuery = tempQuery.Scopes(DBSearch(fields.Search, query.Search))
 	}
-	if filterMap != nil {
-		tempQuery = tempQuery.Scopes(DBFilter(filterMap))
+	if len(query.Filters) != 0 {
+		tempQuery = tempQuery.Scopes(DBFilter(query.Filters))
 	}
 	tempQuery.Model(results).Count(count)
 
@@ -212,10 +326,10 @@ func ListPageSearchFilterOrder(results interface{}, count interface{}, page uint
 		}
 		tempQuery = tempQuery.Scopes(DBPage(page, pageSize))
 	}
-	if orderCols != nil {
-		tempQuery = tempQuery.Scopes(DBOrder(orderCols))
+	if len(query.Orders) != 0 {
+		tempQuery = tempQuery.Scopes(DBOrder(query.Orders))
 	}
-	result := tempQuery.Find(results)
+	result := tempQuery.Scopes(fields.DBSparse(results, fields.ListSparse(query.Sparse))).Find(results)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
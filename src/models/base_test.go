@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestUpdateByMapOrStructErrors(t *testing.T) {
+	openTestDB(t)
+
+	group := &Group{Name: "staff"}
+	if err := group.Create(); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{"description": "staff group"}
+
+	if err := UpdateByMapOrStruct(&Group{ID: group.ID}, values, group.Version+1); err != ErrVersionConflict {
+		t.Errorf("stale version: got %v, want %v", err, ErrVersionConflict)
+	}
+	if err := UpdateByMapOrStruct(&Group{ID: group.ID + 1}, values, group.Version); err != gorm.ErrRecordNotFound {
+		t.Errorf("missing record: got %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+	if err := db.Delete(&Group{ID: group.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateByMapOrStruct(&Group{ID: group.ID}, values, 0); err != gorm.ErrRecordNotFound {
+		t.Errorf("deleted record: got %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
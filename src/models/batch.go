@@ -0,0 +1,185 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// 批量操作中每一项的结果
+const (
+	BatchUpdated   = "updated"
+	BatchUnchanged = "unchanged"
+	BatchNotFound  = "not_found"
+	BatchForbidden = "forbidden"
+)
+
+// BatchResult 批量操作中每个id的结果, 与请求的id顺序一致
+type BatchResult struct {
+	ID     uint   `json:"id"`
+	Status string `json:"status"`
+}
+
+// CountBatchUpdated 统计实际修改的项数
+func CountBatchUpdated(results []*BatchResult) int {
+	count := 0
+	for _, result := range results {
+		if result.Status == BatchUpdated {
+			count++
+		}
+	}
+	return count
+}
+
+// SetUsersActiveTx 批量启用/禁用用户, 不存在或已删除的用户跳过
+// manageSuperuser 为 false 时超级管理员不修改, 结果为 forbidden
+// 每个修改的用户递增版本并记录变更历史
+func SetUsersActiveTx(tx *gorm.DB, ids []uint, active bool, manageSuperuser bool) ([]*BatchResult, error) {
+	results := make([]*BatchResult, 0, len(ids))
+	for _, id := range ids {
+		result := &BatchResult{ID: id, Status: BatchUpdated}
+		results = append(results, result)
+
+		user := User{ID: id}
+		query := tx.Select("id, active, superuser").First(&user)
+		switch {
+		case query.RecordNotFound():
+			result.Status = BatchNotFound
+			continue
+		case query.Error != nil:
+			logrus.Error(query.Error)
+			return nil, query.Error
+		case user.Superuser && !manageSuperuser:
+			result.Status = BatchForbidden
+			continue
+		case user.Active == active:
+			result.Status = BatchUnchanged
+			continue
+		}
+
+		if err := bumpVersion(tx, &user, 0); err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&user).Update("active", active).Error; err != nil {
+			logrus.Error(err)
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// ChangeAssociationTx 追加或移除 owner 的关联, 如 group 的 Users
+// 追加时已存在的关联、移除时不存在的关联为 unchanged, 追加不存在或已删除的记录为 not_found
+// owner 需设置主键, 不存在或已删除时返回 gorm.ErrRecordNotFound; 有变化时递增 owner 的版本并记录变更历史
+func ChangeAssociationTx(tx *gorm.DB, owner Versioned, association string, ids []uint, remove bool) ([]*BatchResult, error) {
+	assoc, snapshot, err := loadAssociation(tx, owner, association)
+	if err != nil {
+		return nil, err
+	}
+	current := map[uint]bool{}
+	for _, id := range snapshotIds(snapshot, assoc.key) {
+		current[id] = true
+	}
+
+	// 追加的记录需存在且未删除
+	exist := map[uint]bool{}
+	if !remove && len(ids) != 0 {
+		var existIds []uint
+		target := historyTypes[assoc.target].newModel(0)
+		if err = tx.Model(target).Where("id IN (?)", ids).Pluck("id", &existIds).Error; err != nil {
+			logrus.Error(err)
+			return nil, err
+		}
+		for _, id := range existIds {
+			exist[id] = true
+		}
+	}
+
+	results := make([]*BatchResult, 0, len(ids))
+	var values []interface{}
+	for _, id := range ids {
+		result := &BatchResult{ID: id, Status: BatchUpdated}
+		results = append(results, result)
+		switch {
+		case !remove && !exist[id]:
+			result.Status = BatchNotFound
+		case current[id] != remove:
+			result.Status = BatchUnchanged
+		default:
+			current[id] = !remove
+			values = append(values, historyTypes[assoc.target].newModel(id))
+		}
+	}
+	if len(values) == 0 {
+		return results, nil
+	}
+
+	if err = trackHistory(tx, owner); err != nil {
+		return nil, err
+	}
+	if err = bumpVersion(tx, owner, 0); err != nil {
+		return nil, err
+	}
+	query := tx.Model(owner).Association(association)
+	if remove {
+		err = query.Delete(values...).Error
+	} else {
+		err = query.Append(values...).Error
+	}
+	if err != nil {
+		logrus.Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// loadAssociation 查找 owner 的关联定义并读取当前快照, owner 不存在或已删除时返回 gorm.ErrRecordNotFound
+func loadAssociation(tx *gorm.DB, owner Versioned, association string) (*historyAssoc, map[string]interface{}, error) {
+	key, ok := historyKeyOf(tx.NewScope(owner))
+	if !ok {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	var assoc *historyAssoc
+	for i := range historyTypes[key.recordType].assocs {
+		if historyTypes[key.recordType].assocs[i].name == association {
+			assoc = &historyTypes[key.recordType].assocs[i]
+		}
+	}
+	if assoc == nil {
+		return nil, nil, fmt.Errorf("%s has no association %s", key.recordType, association)
+	}
+
+	snapshot, err := loadSnapshot(tx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if snapshot == nil || snapshot["deleted_at"] != nil {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	return assoc, snapshot, nil
+}
+
+// Membership owner 某个关联的当前id, 如组的成员, 输出为 {"id": 1, "version": 2, "user_ids": [...]}
+type Membership struct {
+	ID      uint
+	Version uint
+	Key     string // 关联id的字段名, 如 user_ids
+	Ids     []uint
+}
+
+func (s *Membership) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"id": s.ID, "version": s.Version, s.Key: s.Ids})
+}
+
+// LoadMembership 读取 owner 某个关联的当前id及 owner 的版本
+func LoadMembership(tx *gorm.DB, owner Versioned, association string) (*Membership, error) {
+	assoc, snapshot, err := loadAssociation(tx, owner, association)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := snapshot["id"].(float64)
+	version, _ := snapshot["version"].(float64)
+	return &Membership{ID: uint(id), Version: uint(version), Key: assoc.key, Ids: snapshotIds(snapshot, assoc.key)}, nil
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// cursor 游标内容, 对客户端不透明
+type cursor struct {
+	Sort  string          `json:"s"` // 排序字段, 翻页时不能改变
+	Value json.RawMessage `json:"v"` // 排序字段的值
+	ID    uint            `json:"id"`
+	Prev  bool            `json:"p,omitempty"` // 向前翻页
+}
+
+// CursorPage 游标分页结果, 没有下一页/上一页时对应游标为空
+type CursorPage struct {
+	NextCursor string `json:"next_cursor"`
+	PrevCursor string `json:"prev_cursor"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+	}
+	return &c, nil
+}
+
+// ListCursor 按排序字段和id做 keyset 分页, 避免大表 OFFSET 扫描
+// tx 为查询使用的连接, 如 GetDB(RoleReplica)
+// results 为切片指针, 如 *[]*User
+// cursorStr 为空表示第一页; count 为 nil 时不统计总数
+// 只使用 query.Orders 中的第一个排序字段, 未指定时按 id 排序
+func ListCursor(tx *gorm.DB, results interface{}, count interface{}, cursorStr string, limit uint, fields QueryFields, query *ListQuery, scopes ...func(*gorm.DB) *gorm.DB) (*CursorPage, error) {
+	if err := fields.Check(query); err != nil {
+		return nil, err
+	}
+
+	sortCol, desc := "id", false
+	if len(query.Orders) != 0 {
+		parts := strings.Fields(query.Orders[0])
+		sortCol, desc = parts[0], parts[1] == "DESC"
+	}
+
+	sliceValue := reflect.ValueOf(results).Elem()
+	elemType := sliceValue.Type().Elem()
+	sortField, ok := db.NewScope(reflect.New(indirectType(elemType)).Interface()).FieldByName(sortCol)
+	if !ok {
+		return nil, fmt.Errorf("%w: can not sort by %s", ErrInvalidQuery, sortCol)
+	}
+
+	tempQuery := tx.Scopes(scopes...)
+	if query.Search != "" {
+		tempQuery = tempQuery.Scopes(DBSearch(fields.Search, query.Search))
+	}
+	if len(query.Filters) != 0 {
+		tempQuery = tempQuery.Scopes(DBFilter(query.Filters))
+	}
+	// count 为 nil 的 *uint 时接口不为 nil, 需检查指向的值
+	if count != nil && !reflect.ValueOf(count).IsNil() {
+		tempQuery.Model(results).Count(count)
+	}
+
+	var current *cursor
+	if cursorStr != "" {
+		var err error
+		if current, err = decodeCursor(cursorStr); err != nil {
+			return nil, err
+		}
+		if current.Sort != sortCol {
+			return nil, fmt.Errorf("%w: cursor sort mismatch", ErrInvalidQuery)
+		}
+		value := reflect.New(sortField.Struct.Type)
+		if err = json.Unmarshal(current.Value, value.Interface()); err != nil {
+			return nil, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+		}
+
+		// 向前翻页时比较方向相反
+		op := ">"
+		if desc != current.Prev {
+			op = "<"
+		}
+		tempQuery = tempQuery.Where(
+			fmt.Sprintf("%s %s ? OR (%s = ? AND id %s ?)", sortCol, op, sortCol, op),
+			value.Elem().Interface(), value.Elem().Interface(), current.ID,
+		)
+	}
+
+	backward := current != nil && current.Prev
+	direction := "ASC"
+	if desc != backward {
+		direction = "DESC"
+	}
+	// 多取一条判断是否还有数据
+	tempQuery = tempQuery.Scopes(fields.DBSparse(results, fields.ListSparse(query.Sparse), sortCol))
+	result := tempQuery.Order(fmt.Sprintf("%s %s, id %s", sortCol, direction, direction)).Limit(limit + 1).Find(results)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return nil, result.Error
+	}
+
+	hasMore := uint(sliceValue.Len()) > limit
+	if hasMore {
+		sliceValue.Set(sliceValue.Slice(0, int(limit)))
+	}
+	if backward {
+		reverseSlice(sliceValue)
+	}
+
+	page := &CursorPage{}
+	n := sliceValue.Len()
+	if n == 0 {
+		return page, nil
+	}
+	// 向后翻页时, 有更多数据才有下一页, 带游标说明有上一页; 向前翻页反之
+	if backward || hasMore {
+		page.NextCursor = makeCursor(sliceValue.Index(n-1).Interface(), sortCol, false)
+	}
+	if (backward && hasMore) || (!backward && current != nil) {
+		page.PrevCursor = makeCursor(sliceValue.Index(0).Interface(), sortCol, true)
+	}
+	return page, nil
+}
+
+func makeCursor(item interface{}, sortCol string, prev bool) string {
+	scope := db.NewScope(item)
+	field, _ := scope.FieldByName(sortCol)
+	value, _ := json.Marshal(field.Field.Interface())
+	id, _ := scope.PrimaryKeyValue().(uint)
+	return encodeCursor(cursor{Sort: sortCol, Value: value, ID: id, Prev: prev})
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func reverseSlice(v reflect.Value) {
+	swap := reflect.Swapper(v.Interface())
+	for i, j := 0, v.Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
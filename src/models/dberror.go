@@ -0,0 +1,47 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// MySQL 错误号
+const (
+	mysqlDuplicateEntry  = 1062
+	mysqlRowIsReferenced = 1451
+	mysqlNoReferencedRow = 1452
+)
+
+// Postgres SQLSTATE
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+)
+
+// IsDuplicateError 违反唯一约束, 如用户名/权限名重复
+func IsDuplicateError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		return mysqlErr.Number == mysqlDuplicateEntry
+	case errors.As(err, &pqErr):
+		return pqErr.Code == pqUniqueViolation
+	}
+	return isSQLiteDuplicate(err)
+}
+
+// IsForeignKeyError 违反外键约束, 如删除被引用的记录或引用不存在的记录
+func IsForeignKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		return mysqlErr.Number == mysqlRowIsReferenced || mysqlErr.Number == mysqlNoReferencedRow
+	case errors.As(err, &pqErr):
+		return pqErr.Code == pqForeignKeyViolation
+	}
+	return isSQLiteForeignKey(err)
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package models
+
+// 未启用 cgo 时无法使用 sqlite3, 不会出现 sqlite 错误
+
+func isSQLiteDuplicate(err error) bool {
+	return false
+}
+
+func isSQLiteForeignKey(err error) bool {
+	return false
+}
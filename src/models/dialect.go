@@ -0,0 +1,73 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+const (
+	DBTypeMySQL    = "mysql"
+	DBTypePostgres = "postgres"
+	DBTypeSQLite   = "sqlite3"
+)
+
+// SQLiteMemory DBPath 为该值时使用内存数据库, 用于本地开发和测试
+const SQLiteMemory = ":memory:"
+
+// DSN 按 DBType 生成连接字符串, host 为主库或从库地址, sqlite3 不使用
+func DSN(host string) (string, error) {
+	conf := config.AppConfig
+	switch conf.DBType {
+	case DBTypeMySQL:
+		return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local",
+			conf.DBUser, conf.DBPassword, host, conf.DBDatabase), nil
+
+	case DBTypePostgres:
+		hostname, port, err := net.SplitHostPort(host)
+		if err != nil {
+			hostname, port = host, "5432"
+		}
+		query := url.Values{}
+		query.Set("sslmode", conf.DBSSLMode)
+		if conf.DBSSLMode == "" {
+			query.Set("sslmode", "disable")
+		}
+		if conf.DBSchema != "" {
+			query.Set("search_path", conf.DBSchema)
+		}
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(conf.DBUser, conf.DBPassword),
+			Host:     net.JoinHostPort(hostname, port),
+			Path:     "/" + conf.DBDatabase,
+			RawQuery: query.Encode(),
+		}
+		return u.String(), nil
+
+	case DBTypeSQLite:
+		if conf.DBPath == "" || conf.DBPath == SQLiteMemory {
+			// 共享缓存, 连接池中的连接使用同一个内存数据库
+			return "file::memory:?cache=shared&_busy_timeout=5000", nil
+		}
+		if err := utils.IsNotExistMkDir(filepath.Dir(conf.DBPath)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("file:%s?_busy_timeout=5000", conf.DBPath), nil
+	}
+	return "", fmt.Errorf("unsupported db type: %s", conf.DBType)
+}
+
+// quoteTable 按数据库方言转义表名, 拼接原生SQL时使用
+// user/group 等表名在部分数据库中是保留字
+func quoteTable(tx *gorm.DB, model interface{}) string {
+	return tx.Dialect().Quote(tx.NewScope(model).TableName())
+}
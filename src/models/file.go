@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type File struct {
+	ID         uint      `gorm:"primaryKey" uri:"id" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	OwnerID    uint      `gorm:"index;not null" json:"owner_id"`
+	Name       string    `gorm:"type:varchar(255);not null" description:"原始文件名" json:"name"`
+	StorageKey string    `gorm:"type:varchar(255);not null;unique" json:"-"`
+	Size       int64     `json:"size"`
+	Hash       string    `gorm:"type:char(64);index" description:"sha256" json:"hash"`
+	MimeType   string    `gorm:"type:varchar(100)" json:"mime_type"`
+}
+
+func (s *File) Create() error {
+	result := db.Create(s)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+func (s *File) Delete() error {
+	if s.ID == 0 {
+		return nil
+	}
+	result := db.Delete(s)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+func GetFileByStorageKey(key string) (*File, error) {
+	var file File
+	result := db.Where("storage_key = ?", key).First(&file)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return nil, result.Error
+	}
+	return &file, nil
+}
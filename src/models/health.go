@@ -0,0 +1,178 @@
+package models
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+)
+
+// 连接池默认配置, 对应配置项为0时使用
+const (
+	defaultMaxIdleConns  = 10
+	defaultMaxOpenConns  = 100
+	defaultPingInterval  = 10 * time.Second
+	maxConnectRetryDelay = 30 * time.Second
+)
+
+// dbHealth 最近一次检查结果
+type dbHealth struct {
+	mu        sync.RWMutex
+	healthy   bool
+	err       string
+	checkedAt time.Time
+}
+
+func (h *dbHealth) set(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.healthy = err == nil
+	h.err = ""
+	if err != nil {
+		h.err = err.Error()
+	}
+	h.checkedAt = time.Now()
+}
+
+func (h *dbHealth) get() (bool, string, time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.healthy, h.err, h.checkedAt
+}
+
+var primaryHealth dbHealth
+
+// DBStats 连接池统计, 来自 sql.DBStats
+type DBStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDuration       int64 `json:"wait_duration"` // 毫秒
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// DBStatus 主库或从库的健康状态和连接池统计
+type DBStatus struct {
+	Role      string    `json:"role"`
+	Host      string    `json:"host"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Stats     *DBStats  `json:"stats"`
+}
+
+func newDBStats(s sql.DBStats) *DBStats {
+	return &DBStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+// GetDBStatus 主库及所有从库的状态, 主库在第一个
+func GetDBStatus() []*DBStatus {
+	host := config.AppConfig.DBHost
+	if config.AppConfig.DBType == DBTypeSQLite {
+		host = config.AppConfig.DBPath
+	}
+	healthy, errMsg, checkedAt := primaryHealth.get()
+	statuses := []*DBStatus{{
+		Role:      "primary",
+		Host:      host,
+		Healthy:   healthy,
+		Error:     errMsg,
+		CheckedAt: checkedAt,
+		Stats:     newDBStats(db.DB().Stats()),
+	}}
+
+	for _, r := range replicas {
+		healthy, errMsg, checkedAt := r.health.get()
+		status := &DBStatus{
+			Role:      "replica",
+			Host:      r.host,
+			Healthy:   healthy,
+			Error:     errMsg,
+			CheckedAt: checkedAt,
+		}
+		if conn := r.conn(); conn != nil {
+			status.Stats = newDBStats(conn.DB().Stats())
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// DBHealthy 主库最近一次检查是否正常
+func DBHealthy() bool {
+	healthy, _, _ := primaryHealth.get()
+	return healthy
+}
+
+// setPool 按配置设置连接池
+func setPool(sqlDB *sql.DB) {
+	conf := config.AppConfig
+	maxIdle, maxOpen := conf.DBMaxIdleConns, conf.DBMaxOpenConns
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+	if maxOpen == 0 {
+		maxOpen = defaultMaxOpenConns
+	}
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetMaxOpenConns(maxOpen)
+
+	// 内存数据库在所有连接关闭后丢失, 不限制连接时长
+	if conf.DBType == DBTypeSQLite && (conf.DBPath == "" || conf.DBPath == SQLiteMemory) {
+		return
+	}
+	sqlDB.SetConnMaxLifetime(time.Duration(conf.DBConnMaxLifetime) * time.Second)
+	sqlDB.SetConnMaxIdleTime(time.Duration(conf.DBConnMaxIdleTime) * time.Second)
+}
+
+// connectWithRetry 启动时数据库可能尚未就绪, 失败后按指数退避重试 DBConnectRetry 次
+func connectWithRetry(host string) (*gorm.DB, error) {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		conn, err := openDB(host)
+		if err == nil || attempt >= config.AppConfig.DBConnectRetry {
+			return conn, err
+		}
+		logrus.Warnf("connect db %s failed, retry in %s: %v", host, delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxConnectRetryDelay {
+			delay = maxConnectRetryDelay
+		}
+	}
+}
+
+// runPinger 定时检查主库和从库, 状态变化时记录日志
+func runPinger() {
+	interval := time.Duration(config.AppConfig.DBPingInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultPingInterval
+	}
+	for range time.Tick(interval) {
+		wasHealthy := DBHealthy()
+		err := db.DB().Ping()
+		primaryHealth.set(err)
+		if err != nil {
+			logrus.Error("db ping error: ", err)
+		} else if !wasHealthy {
+			logrus.Info("db recovered")
+		}
+		checkReplicas()
+	}
+}
@@ -0,0 +1,542 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// 变更历史的记录类型, 与表名相同
+const (
+	HistoryUser       = "user"
+	HistoryGroup      = "group"
+	HistoryPermission = "permission"
+)
+
+// 变更历史的操作
+const (
+	HistoryCreate  = "create"
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+	HistoryPurge   = "purge"
+	HistoryRevert  = "revert"
+)
+
+const (
+	historyActorKey   = "history:actor"
+	historyChangesKey = "history:changes"
+)
+
+// RecordHistory 用户/组/权限的变更历史, 一个事务中每条记录最多产生一个版本
+// 关联关系(中间表)的变化同时记录到双方的历史中
+type RecordHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	RecordType string    `gorm:"type:varchar(20);not null;index:idx_record_history_record" json:"record_type"`
+	RecordID   uint      `gorm:"not null;index:idx_record_history_record" json:"record_id"`
+	Revision   uint      `gorm:"not null" description:"记录的版本号, 从1开始" json:"revision"`
+	Action     string    `gorm:"type:varchar(20);not null" json:"action"`
+	ActorID    uint      `description:"操作人, 0表示系统(如启动初始化/命令行)" json:"actor_id"`
+	ActorName  string    `gorm:"type:varchar(50)" json:"actor_name"`
+	Diff       JSONText  `gorm:"type:text" description:"变更字段的前后值" json:"diff"`
+	Snapshot   JSONText  `gorm:"type:text" description:"变更后的完整数据, 不含密码, 用于回滚" json:"snapshot"`
+}
+
+func (RecordHistory) TableName() string {
+	return "record_history"
+}
+
+// JSONText 以文本存储的JSON, 输出时不再转义为字符串
+type JSONText string
+
+func (j JSONText) MarshalJSON() ([]byte, error) {
+	if j == "" {
+		return []byte("null"), nil
+	}
+	return []byte(j), nil
+}
+
+// FieldChange 字段的前后值, 创建时 before 为 null, 彻底删除时 after 为 null
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type historyAssoc struct {
+	key     string // 快照中的字段, 如 group_ids
+	name    string // 关联名, 如 Groups
+	target  string // 关联的记录类型
+	reverse string // 关联记录快照中对应的字段
+}
+
+type historyType struct {
+	newModel func(id uint) Versioned
+	columns  []string // 回滚时恢复的字段
+	assocs   []historyAssoc
+}
+
+var historyTypes = map[string]*historyType{
+	HistoryUser: {
+		newModel: func(id uint) Versioned { return &User{ID: id} },
+		// superuser 只有超级管理员可以修改, 不随回滚恢复
+		columns: []string{"username", "chinese_name", "active", "phone", "avatar", "locale"},
+		assocs: []historyAssoc{
+			{key: "group_ids", name: "Groups", target: HistoryGroup, reverse: "user_ids"},
+			{key: "permission_ids", name: "Permissions", target: HistoryPermission, reverse: "user_ids"},
+		},
+	},
+	HistoryGroup: {
+		newModel: func(id uint) Versioned { return &Group{ID: id} },
+		columns:  []string{"name", "description"},
+		assocs: []historyAssoc{
+			{key: "user_ids", name: "Users", target: HistoryUser, reverse: "group_ids"},
+			{key: "permission_ids", name: "Permissions", target: HistoryPermission, reverse: "group_ids"},
+		},
+	},
+	HistoryPermission: {
+		newModel: func(id uint) Versioned { return &Permission{ID: id} },
+		columns:  []string{"name", "description"},
+		assocs: []historyAssoc{
+			{key: "user_ids", name: "Users", target: HistoryUser, reverse: "permission_ids"},
+			{key: "group_ids", name: "Groups", target: HistoryGroup, reverse: "permission_ids"},
+		},
+	},
+}
+
+// 不写入快照的字段, 密码不记录
+var snapshotOmit = []string{"password", "users", "groups", "permissions"}
+
+// 不计入变更的字段
+var diffOmit = []string{"id", "created_at", "updated_at", "version"}
+
+type historyKey struct {
+	recordType string
+	id         uint
+}
+
+// historyChanges 收集一个事务中修改的记录, 提交前逐条写入历史
+type historyChanges struct {
+	actor  *Actor
+	action string // 非空时覆盖记录的操作, 如 revert
+	keys   []historyKey
+	before map[historyKey]map[string]interface{}
+}
+
+// relatedChange 关联记录因中间表变化产生的修改
+type relatedChange struct {
+	key   string
+	owner uint
+	added bool
+}
+
+// WithActor 返回携带操作人的连接, 在其上执行的写操作记录到变更历史中, 授权变化记录到审计日志中
+func WithActor(tx *gorm.DB, actor *Actor) *gorm.DB {
+	if actor == nil {
+		return tx
+	}
+	return tx.Set(historyActorKey, actor)
+}
+
+func newHistoryChanges(tx *gorm.DB) *historyChanges {
+	changes := &historyChanges{before: map[historyKey]map[string]interface{}{}}
+	if actor, ok := tx.Get(historyActorKey); ok {
+		changes.actor = actor.(*Actor)
+	}
+	return changes
+}
+
+// registerHistoryCallbacks 在增删改前后记录变更
+// 在 WithTxFrom 事务中时提交前统一写入, 否则每条语句执行后写入
+func registerHistoryCallbacks(conn *gorm.DB) {
+	callback := conn.Callback()
+	callback.Create().After("gorm:create").Register("history:track", historyTrack(true))
+	callback.Create().After("gorm:after_create").Register("history:flush", historyFlush)
+	callback.Update().Before("gorm:update").Register("history:track", historyTrack(false))
+	callback.Update().After("gorm:after_update").Register("history:flush", historyFlush)
+	callback.Delete().Before("gorm:delete").Register("history:track", historyTrack(false))
+	callback.Delete().After("gorm:after_delete").Register("history:flush", historyFlush)
+}
+
+func historyTrack(created bool) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		if scope.HasError() {
+			return
+		}
+		key, ok := historyKeyOf(scope)
+		if !ok {
+			return
+		}
+
+		var changes *historyChanges
+		if value, ok := scope.Get(historyChangesKey); ok {
+			changes = value.(*historyChanges)
+		} else {
+			changes = newHistoryChanges(scope.DB())
+			scope.InstanceSet(historyChangesKey, changes)
+		}
+		if err := changes.track(scope.NewDB(), key, created); err != nil {
+			scope.Err(err)
+		}
+	}
+}
+
+func historyFlush(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+	if value, ok := scope.InstanceGet(historyChangesKey); ok {
+		if err := value.(*historyChanges).flush(scope.NewDB()); err != nil {
+			scope.Err(err)
+		}
+	}
+}
+
+// historyKeyOf 只记录按主键修改的单条记录, 批量修改需先调用 trackHistory
+func historyKeyOf(scope *gorm.Scope) (historyKey, bool) {
+	recordType := scope.TableName()
+	if _, ok := historyTypes[recordType]; !ok {
+		return historyKey{}, false
+	}
+	id, ok := scope.PrimaryKeyValue().(uint)
+	if !ok || id == 0 {
+		return historyKey{}, false
+	}
+	return historyKey{recordType: recordType, id: id}, true
+}
+
+// trackHistory 只修改中间表时不会触发回调, 修改关联前调用以记录修改前的数据
+// 不在 WithTxFrom 事务中时不记录
+func trackHistory(tx *gorm.DB, model interface{}) error {
+	value, ok := tx.Get(historyChangesKey)
+	if !ok {
+		return nil
+	}
+	key, ok := historyKeyOf(tx.NewScope(model))
+	if !ok {
+		return nil
+	}
+	return value.(*historyChanges).track(tx, key, false)
+}
+
+// track 第一次修改时记录修改前的快照
+func (s *historyChanges) track(tx *gorm.DB, key historyKey, created bool) error {
+	if _, ok := s.before[key]; ok {
+		return nil
+	}
+	var before map[string]interface{}
+	if !created {
+		var err error
+		if before, err = loadSnapshot(tx, key); err != nil {
+			return err
+		}
+	}
+	s.keys = append(s.keys, key)
+	s.before[key] = before
+	return nil
+}
+
+// flush 对比修改前后的快照写入历史, 关联关系变化同时写入未修改的关联记录
+func (s *historyChanges) flush(tx *gorm.DB) error {
+	var relatedKeys []historyKey
+	related := map[historyKey][]relatedChange{}
+
+	for _, key := range s.keys {
+		before := s.before[key]
+		after, err := loadSnapshot(tx, key)
+		if err != nil {
+			return err
+		}
+		if err = s.write(tx, key, before, after, s.action); err != nil {
+			return err
+		}
+		// 关联另一方的变化与本条相同, 只审计直接修改的记录
+		if err = auditPrivilege(tx, s.actor, key, before, after); err != nil {
+			return err
+		}
+
+		for _, assoc := range historyTypes[key.recordType].assocs {
+			added, removed := diffIds(snapshotIds(before, assoc.key), snapshotIds(after, assoc.key))
+			for i, ids := range [][]uint{added, removed} {
+				for _, id := range ids {
+					relatedKey := historyKey{recordType: assoc.target, id: id}
+					if _, ok := s.before[relatedKey]; ok {
+						continue
+					}
+					if _, ok := related[relatedKey]; !ok {
+						relatedKeys = append(relatedKeys, relatedKey)
+					}
+					related[relatedKey] = append(related[relatedKey], relatedChange{key: assoc.reverse, owner: key.id, added: i == 0})
+				}
+			}
+		}
+	}
+
+	for _, key := range relatedKeys {
+		after, err := loadSnapshot(tx, key)
+		if err != nil {
+			return err
+		}
+		if after == nil {
+			continue
+		}
+		// 由修改后的快照反推修改前的关联
+		before := make(map[string]interface{}, len(after))
+		for k, v := range after {
+			before[k] = v
+		}
+		for _, change := range related[key] {
+			ids := snapshotIds(before, change.key)
+			if change.added {
+				_, ids = diffIds(ids, []uint{change.owner})
+				ids = append([]uint{}, ids...)
+			} else {
+				ids = append(ids, change.owner)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			before[change.key] = ids
+		}
+		if err = s.write(tx, key, before, after, HistoryUpdate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *historyChanges) write(tx *gorm.DB, key historyKey, before, after map[string]interface{}, action string) error {
+	diff := diffSnapshot(before, after)
+	if len(diff) == 0 {
+		return nil
+	}
+	if action == "" {
+		action = historyAction(before, after)
+	}
+
+	var revision uint
+	row := tx.Model(&RecordHistory{}).Where("record_type = ? AND record_id = ?", key.recordType, key.id).
+		Select("COALESCE(MAX(revision), 0)").Row()
+	if err := row.Scan(&revision); err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	snapshotJSON, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	history := &RecordHistory{
+		RecordType: key.recordType,
+		RecordID:   key.id,
+		Revision:   revision + 1,
+		Action:     action,
+		Diff:       JSONText(diffJSON),
+		Snapshot:   JSONText(snapshotJSON),
+	}
+	if s.actor != nil {
+		history.ActorID, history.ActorName = s.actor.ID, s.actor.Name
+	}
+	result := tx.Create(history)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+	}
+	return result.Error
+}
+
+// loadSnapshot 读取记录及其关联id, 记录已彻底删除时返回 nil
+func loadSnapshot(tx *gorm.DB, key historyKey) (map[string]interface{}, error) {
+	ht := historyTypes[key.recordType]
+	model := ht.newModel(key.id)
+	result := tx.Unscoped().First(model)
+	if result.RecordNotFound() {
+		return nil, nil
+	}
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return nil, result.Error
+	}
+	if err := model.LoadAllAssociationIds(tx); err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot map[string]interface{}
+	if err = json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, err
+	}
+	for _, key := range snapshotOmit {
+		delete(snapshot, key)
+	}
+	// 关联id排序后比较
+	for _, assoc := range ht.assocs {
+		ids := snapshotIds(snapshot, assoc.key)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		snapshot[assoc.key] = ids
+	}
+	return snapshot, nil
+}
+
+// snapshotIds 读取快照中的关联id, 快照可能来自数据库中的JSON
+func snapshotIds(snapshot map[string]interface{}, key string) []uint {
+	ids := []uint{}
+	switch values := snapshot[key].(type) {
+	case []uint:
+		ids = append(ids, values...)
+	case []interface{}:
+		for _, v := range values {
+			if id, ok := v.(float64); ok {
+				ids = append(ids, uint(id))
+			}
+		}
+	}
+	return ids
+}
+
+// diffIds 返回 after 中新增的和 before 中移除的id
+func diffIds(before, after []uint) (added []uint, removed []uint) {
+	beforeSet := make(map[uint]bool, len(before))
+	for _, id := range before {
+		beforeSet[id] = true
+	}
+	afterSet := make(map[uint]bool, len(after))
+	for _, id := range after {
+		afterSet[id] = true
+		if !beforeSet[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range before {
+		if !afterSet[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+func diffSnapshot(before, after map[string]interface{}) map[string]*FieldChange {
+	diff := map[string]*FieldChange{}
+	for _, snapshot := range []map[string]interface{}{before, after} {
+		for key := range snapshot {
+			if contains(diffOmit, key) || diff[key] != nil {
+				continue
+			}
+			if !reflect.DeepEqual(before[key], after[key]) {
+				diff[key] = &FieldChange{Before: before[key], After: after[key]}
+			}
+		}
+	}
+	return diff
+}
+
+func historyAction(before, after map[string]interface{}) string {
+	switch {
+	case before == nil:
+		return HistoryCreate
+	case after == nil:
+		return HistoryPurge
+	case before["deleted_at"] == nil && after["deleted_at"] != nil:
+		return HistoryDelete
+	case before["deleted_at"] != nil && after["deleted_at"] == nil:
+		return HistoryRestore
+	}
+	return HistoryUpdate
+}
+
+// DBHistoryOf 只查询指定记录的变更历史
+func DBHistoryOf(recordType string, id uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("record_type = ? AND record_id = ?", recordType, id)
+	}
+}
+
+// RevertHistory 把记录的字段和关联恢复为指定版本的快照, 回滚本身产生一个新版本
+// 密码及用户的 superuser 不会回滚; 已彻底删除的关联记录不再恢复; 已软删除的记录需先恢复
+// version 为客户端提交的版本, 为0时不校验
+func RevertHistory(conn *gorm.DB, recordType string, id uint, revision uint, version uint) (Versioned, error) {
+	ht, ok := historyTypes[recordType]
+	if !ok {
+		return nil, ErrRevisionNotFound
+	}
+	model := ht.newModel(id)
+
+	err := WithTxFrom(conn, func(tx *gorm.DB) error {
+		var history RecordHistory
+		result := tx.Where("record_type = ? AND record_id = ? AND revision = ?", recordType, id, revision).First(&history)
+		if result.RecordNotFound() {
+			return ErrRevisionNotFound
+		}
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+		var snapshot map[string]interface{}
+		if err := json.Unmarshal([]byte(history.Snapshot), &snapshot); err != nil || snapshot == nil {
+			// 彻底删除的版本没有快照
+			return ErrRevisionNotFound
+		}
+
+		if result = tx.First(model); result.Error != nil {
+			return result.Error
+		}
+		if changes, ok := tx.Get(historyChangesKey); ok {
+			changes.(*historyChanges).action = HistoryRevert
+		}
+		if err := bumpVersion(tx, model, version); err != nil {
+			return err
+		}
+
+		values := map[string]interface{}{}
+		for _, column := range ht.columns {
+			if value, ok := snapshot[column]; ok {
+				values[column] = value
+			}
+		}
+		if result = tx.Model(model).Updates(values); result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+
+		for _, assoc := range ht.assocs {
+			var exist []uint
+			if ids := snapshotIds(snapshot, assoc.key); len(ids) != 0 {
+				target := historyTypes[assoc.target].newModel(0)
+				if result = tx.Unscoped().Model(target).Where("id IN (?)", ids).Pluck("id", &exist); result.Error != nil {
+					logrus.Error(result.Error)
+					return result.Error
+				}
+			}
+			targets := make([]interface{}, 0, len(exist))
+			for _, targetID := range exist {
+				targets = append(targets, historyTypes[assoc.target].newModel(targetID))
+			}
+			if err := tx.Model(model).Association(assoc.name).Replace(targets...).Error; err != nil {
+				logrus.Error(err)
+				return err
+			}
+		}
+
+		// Replace 会把只有id的关联写入模型, 重新读取
+		model = ht.newModel(id)
+		if result = tx.First(model); result.Error != nil {
+			return result.Error
+		}
+		return model.LoadAllAssociationIds(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
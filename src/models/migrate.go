@@ -0,0 +1,407 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/utils"
+)
+
+var (
+	ErrSchemaBehind  = errors.New("database schema is behind, run migrate up")
+	ErrMigrateLocked = errors.New("migration locked by another instance")
+	ErrNoMigration   = errors.New("no migration to roll back")
+)
+
+// 启动时检查迁移的方式, 对应配置 DBMigrateMode
+const (
+	MigrateModeCheck  = "check"  // 有未执行的迁移时拒绝启动, 默认
+	MigrateModeAuto   = "auto"   // 启动时执行未执行的迁移
+	MigrateModeIgnore = "ignore" // 只打印警告
+)
+
+// 锁超时后视为持有者已异常退出, 允许其他实例获取
+const (
+	migrateLockTimeout = 10 * time.Minute
+	migrateLockWait    = 30 * time.Second
+)
+
+// Migration 版本迁移, 按 Version 顺序执行
+// Version 为创建时间, 格式 20060102150405
+// Up/Down 在事务中执行; 注意 MySQL 的 DDL 会隐式提交, 无法回滚
+type Migration struct {
+	Version string
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	Version   string    `gorm:"type:varchar(14);primary_key"`
+	Name      string    `gorm:"type:varchar(100)"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// schemaMigrationLock 迁移锁, 只有一行, 插入成功即获得锁
+type schemaMigrationLock struct {
+	ID       uint      `gorm:"primary_key;auto_increment:false"`
+	Owner    string    `gorm:"type:varchar(100)"`
+	LockedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigrationLock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// MigrationStatus 迁移状态, AppliedAt 为空表示未执行
+// Missing 表示数据库中有记录但代码/文件中没有该迁移
+type MigrationStatus struct {
+	Version   string
+	Name      string
+	AppliedAt *time.Time
+	Missing   bool
+}
+
+var sqlFileRegexp = regexp.MustCompile(`^(\d{14})_(\w+)\.(up|down)\.sql$`)
+
+// loadMigrations 合并代码中的迁移和 DBMigrationPath 中的 SQL 迁移, 按版本排序
+func loadMigrations() ([]*Migration, error) {
+	byVersion := map[string]*Migration{}
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	sqlMigrations, err := loadSQLMigrations(config.AppConfig.DBMigrationPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range sqlMigrations {
+		if _, ok := byVersion[m.Version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %s", m.Version)
+		}
+		byVersion[m.Version] = m
+	}
+
+	list := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// loadSQLMigrations 读取 <version>_<name>.up.sql / .down.sql 文件
+func loadSQLMigrations(dir string) ([]*Migration, error) {
+	if dir == "" || utils.IsNotExist(dir) {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[string]*Migration{}
+	for _, file := range files {
+		match := sqlFileRegexp.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[match[1]]
+		if !ok {
+			m = &Migration{Version: match[1], Name: match[2]}
+			byVersion[match[1]] = m
+		}
+		if match[3] == "up" {
+			m.Up = execSQL(string(content))
+		} else {
+			m.Down = execSQL(string(content))
+		}
+	}
+
+	var list []*Migration
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %s_%s has no up statements", m.Version, m.Name)
+		}
+		list = append(list, m)
+	}
+	return list, nil
+}
+
+// splitSQL 按行尾的分号拆分语句, 忽略空行和 -- 注释
+func splitSQL(content string) []string {
+	var statements []string
+	var statement strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, statement.String())
+			statement.Reset()
+		}
+	}
+	if strings.TrimSpace(statement.String()) != "" {
+		statements = append(statements, statement.String())
+	}
+	return statements
+}
+
+// execSQL 逐条执行SQL, 没有语句时返回 nil
+func execSQL(content string) func(tx *gorm.DB) error {
+	statements := splitSQL(content)
+	if len(statements) == 0 {
+		return nil
+	}
+	return func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func ensureMigrationTables() error {
+	return db.AutoMigrate(&SchemaMigration{}, &schemaMigrationLock{}).Error
+}
+
+func appliedMigrations() (map[string]*SchemaMigration, error) {
+	var records []*SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[string]*SchemaMigration, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// withMigrateLock 持有迁移锁执行 fn, 其他实例正在迁移时最多等待 migrateLockWait
+func withMigrateLock(fn func() error) error {
+	if err := ensureMigrationTables(); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+
+	deadline := time.Now().Add(migrateLockWait)
+	for {
+		lock := schemaMigrationLock{ID: 1, Owner: owner, LockedAt: time.Now()}
+		if db.Create(&lock).Error == nil {
+			break
+		}
+		// 清理超时的锁
+		db.Where("id = 1 AND locked_at < ?", time.Now().Add(-migrateLockTimeout)).Delete(&schemaMigrationLock{})
+		if time.Now().After(deadline) {
+			return ErrMigrateLocked
+		}
+		logrus.Info("waiting for migration lock")
+		time.Sleep(time.Second)
+	}
+	defer func() {
+		if err := db.Where("id = 1 AND owner = ?", owner).Delete(&schemaMigrationLock{}).Error; err != nil {
+			logrus.Error("release migration lock error: ", err)
+		}
+	}()
+	return fn()
+}
+
+// MigrateStatus 所有迁移的执行状态
+func MigrateStatus() ([]*MigrationStatus, error) {
+	if err := ensureMigrationTables(); err != nil {
+		return nil, err
+	}
+	list, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*MigrationStatus
+	for _, m := range list {
+		status := &MigrationStatus{Version: m.Version, Name: m.Name}
+		if r, ok := applied[m.Version]; ok {
+			status.AppliedAt = &r.AppliedAt
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, r := range applied {
+		appliedAt := r.AppliedAt
+		statuses = append(statuses, &MigrationStatus{Version: r.Version, Name: r.Name, AppliedAt: &appliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// PendingMigrations 未执行的迁移
+func PendingMigrations() ([]*Migration, error) {
+	if err := ensureMigrationTables(); err != nil {
+		return nil, err
+	}
+	list, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Migration
+	for _, m := range list {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp 按顺序执行所有未执行的迁移, 返回执行的数量
+func MigrateUp() (int, error) {
+	count := 0
+	err := withMigrateLock(func() error {
+		// 获得锁后再读取, 避免重复执行其他实例刚执行过的迁移
+		pending, err := PendingMigrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			logrus.Infof("migrate up %s_%s", m.Version, m.Name)
+			err = WithTx(func(tx *gorm.DB) error {
+				if err := m.Up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migrate up %s_%s: %w", m.Version, m.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateDown 回滚最近执行的 steps 个迁移, 返回回滚的数量
+func MigrateDown(steps int) (int, error) {
+	count := 0
+	err := withMigrateLock(func() error {
+		list, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		byVersion := map[string]*Migration{}
+		for _, m := range list {
+			byVersion[m.Version] = m
+		}
+
+		var records []*SchemaMigration
+		if err = db.Order("version DESC").Limit(steps).Find(&records).Error; err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return ErrNoMigration
+		}
+
+		for _, r := range records {
+			m, ok := byVersion[r.Version]
+			if !ok || m.Down == nil {
+				return fmt.Errorf("migration %s_%s can not be rolled back", r.Version, r.Name)
+			}
+			logrus.Infof("migrate down %s_%s", m.Version, m.Name)
+			err = WithTx(func(tx *gorm.DB) error {
+				if err := m.Down(tx); err != nil {
+					return err
+				}
+				return tx.Delete(r).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migrate down %s_%s: %w", m.Version, m.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// MigrateCreate 在 DBMigrationPath 中创建 SQL 迁移文件, 返回文件路径
+func MigrateCreate(name string) ([]string, error) {
+	if !regexp.MustCompile(`^\w+$`).MatchString(name) {
+		return nil, fmt.Errorf("invalid migration name: %s", name)
+	}
+	dir := config.AppConfig.DBMigrationPath
+	if err := utils.IsNotExistMkDir(dir); err != nil {
+		return nil, err
+	}
+
+	version := time.Now().Format("20060102150405")
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s.sql", version, name, direction))
+		content := fmt.Sprintf("-- %s %s\n-- 每条语句以分号结尾\n", name, direction)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// CheckMigrate 启动时按 DBMigrateMode 检查数据库结构是否为最新
+func CheckMigrate() error {
+	switch config.AppConfig.DBMigrateMode {
+	case MigrateModeAuto:
+		count, err := MigrateUp()
+		if count != 0 {
+			logrus.Infof("applied %d migrations", count)
+		}
+		return err
+	case MigrateModeIgnore:
+		pending, err := PendingMigrations()
+		if err == nil && len(pending) != 0 {
+			logrus.Warnf("%d migrations pending", len(pending))
+		}
+		return err
+	default:
+		pending, err := PendingMigrations()
+		if err != nil {
+			return err
+		}
+		if len(pending) != 0 {
+			return fmt.Errorf("%w: %d pending", ErrSchemaBehind, len(pending))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// migrations 代码中的迁移, 新增时追加到末尾, 已发布的迁移不能修改
+// 只需执行SQL的迁移可用 migrate create 在 DBMigrationPath 中创建SQL文件
+// 迁移使用下方冻结的表结构, 不使用会继续修改的模型, 否则修改模型后旧迁移的结果也会变化
+var migrations = []*Migration{
+	{
+		// 初始表结构, 与之前启动时 AutoMigrate 的结果一致, 已有数据库执行时只补充缺少的列
+		Version: "20261019000000",
+		Name:    "init",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userV1{}, &permissionV1{}, &groupV1{}, &fileV1{}, &uploadV1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists("user_permission", "user_group", "group_permission",
+				&uploadV1{}, &fileV1{}, &groupV1{}, &permissionV1{}, &userV1{}).Error
+		},
+	},
+	{
+		Version: "20261019000001",
+		Name:    "record_history",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&recordHistoryV1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&recordHistoryV1{}).Error
+		},
+	},
+	{
+		Version: "20261019000002",
+		Name:    "audit_log",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&auditLogV1{}, &auditHeadV1{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&auditHeadV1{ID: 1}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&auditHeadV1{}, &auditLogV1{}).Error
+		},
+	},
+	{
+		Version: "20261019000003",
+		Name:    "user_sessions_revoked_at",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userV2{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumn(tx, &userV1{}, "sessions_revoked_at")
+		},
+	},
+	{
+		Version: "20261019000004",
+		Name:    "user_locale",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userV3{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumn(tx, &userV2{}, "locale")
+		},
+	},
+}
+
+// dropColumn 删除列, model 为删除后的表结构
+// sqlite 3.35 之前不支持 DROP COLUMN, 按 model 新建表并复制数据
+func dropColumn(tx *gorm.DB, model interface{}, column string) error {
+	if tx.Dialect().GetName() != DBTypeSQLite {
+		return tx.Model(model).DropColumn(column).Error
+	}
+
+	scope := tx.NewScope(model)
+	table := scope.TableName()
+	old := table + "_old"
+	// 索引跟随表重命名, 先删除再由 AutoMigrate 在新表上创建; unique 等自动创建的索引 sql 为空, 随旧表删除
+	var indexes []string
+	err := tx.Table("sqlite_master").Where("type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Pluck("name", &indexes).Error
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err = tx.Exec("DROP INDEX " + scope.Quote(index)).Error; err != nil {
+			return err
+		}
+	}
+	if err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", scope.Quote(table), scope.Quote(old))).Error; err != nil {
+		return err
+	}
+	if err = tx.AutoMigrate(model).Error; err != nil {
+		return err
+	}
+
+	var columns []string
+	for _, field := range scope.GetModelStruct().StructFields {
+		if field.IsNormal && !field.IsIgnored {
+			columns = append(columns, scope.Quote(field.DBName))
+		}
+	}
+	list := strings.Join(columns, ", ")
+	err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", scope.Quote(table), list, list, scope.Quote(old))).Error
+	if err != nil {
+		return err
+	}
+	return tx.DropTable(old).Error
+}
+
+// 以下为各迁移执行时的表结构, 已发布后不能修改, 新的修改通过新版本的结构体表示
+
+// userV1 20261019000000_init
+type userV1 struct {
+	ID              uint
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       *time.Time `gorm:"index"`
+	Username        string     `gorm:"type:varchar(50);not null;unique"`
+	Password        string     `gorm:"type:varchar(100)"`
+	ChineseName     string     `gorm:"type:varchar(25)"`
+	Active          bool       `gorm:"default:true"`
+	Superuser       bool       `gorm:"default:false"`
+	Phone           string     `gorm:"type:varchar(20)"`
+	Version         uint       `gorm:"not null;default:1"`
+	Avatar          string     `gorm:"type:varchar(64)"`
+	DeletedUsername string     `gorm:"type:varchar(50)"`
+
+	Permissions []*permissionV1 `gorm:"many2many:user_permission;jointable_foreignkey:user_id;association_jointable_foreignkey:permission_id"`
+	Groups      []*groupV1      `gorm:"many2many:user_group;jointable_foreignkey:user_id;association_jointable_foreignkey:group_id"`
+}
+
+func (userV1) TableName() string {
+	return "user"
+}
+
+type permissionV1 struct {
+	ID          uint
+	DeletedAt   *time.Time `gorm:"index"`
+	Name        string     `gorm:"type:varchar(30);not null;unique"`
+	Description string     `gorm:"type:text"`
+	Version     uint       `gorm:"not null;default:1"`
+
+	Groups []*groupV1 `gorm:"many2many:group_permission;jointable_foreignkey:permission_id;association_jointable_foreignkey:group_id"`
+}
+
+func (permissionV1) TableName() string {
+	return "permission"
+}
+
+type groupV1 struct {
+	ID          uint
+	DeletedAt   *time.Time `gorm:"index"`
+	Name        string     `gorm:"type:varchar(30);not null"`
+	Description string     `gorm:"type:text"`
+	Version     uint       `gorm:"not null;default:1"`
+}
+
+func (groupV1) TableName() string {
+	return "group"
+}
+
+type fileV1 struct {
+	ID         uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	OwnerID    uint   `gorm:"index;not null"`
+	Name       string `gorm:"type:varchar(255);not null"`
+	StorageKey string `gorm:"type:varchar(255);not null;unique"`
+	Size       int64
+	Hash       string `gorm:"type:char(64);index"`
+	MimeType   string `gorm:"type:varchar(100)"`
+}
+
+func (fileV1) TableName() string {
+	return "file"
+}
+
+type uploadV1 struct {
+	ID        string `gorm:"type:char(32)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	OwnerID   uint      `gorm:"index;not null"`
+	Name      string    `gorm:"type:varchar(255);not null"`
+	Size      int64     `gorm:"not null"`
+	Offset    int64     `gorm:"column:received;not null;default:0"`
+	Hash      string    `gorm:"type:char(64)"`
+	ExpiresAt time.Time `gorm:"index"`
+	FileID    uint
+}
+
+func (uploadV1) TableName() string {
+	return "upload"
+}
+
+// recordHistoryV1 20261019000001_record_history
+type recordHistoryV1 struct {
+	ID         uint
+	CreatedAt  time.Time
+	RecordType string `gorm:"type:varchar(20);not null;index:idx_record_history_record"`
+	RecordID   uint   `gorm:"not null;index:idx_record_history_record"`
+	Revision   uint   `gorm:"not null"`
+	Action     string `gorm:"type:varchar(20);not null"`
+	ActorID    uint
+	ActorName  string `gorm:"type:varchar(50)"`
+	Diff       string `gorm:"type:text"`
+	Snapshot   string `gorm:"type:text"`
+}
+
+func (recordHistoryV1) TableName() string {
+	return "record_history"
+}
+
+// auditLogV1 20261019000002_audit_log
+type auditLogV1 struct {
+	ID         uint
+	CreatedAt  time.Time `gorm:"index"`
+	Event      string    `gorm:"type:varchar(30);not null;index"`
+	Outcome    string    `gorm:"type:varchar(20);not null"`
+	ActorID    uint      `gorm:"index"`
+	ActorName  string    `gorm:"type:varchar(50)"`
+	IP         string    `gorm:"type:varchar(45)"`
+	UserAgent  string    `gorm:"type:varchar(255)"`
+	RequestID  string    `gorm:"type:varchar(64)"`
+	TargetType string    `gorm:"type:varchar(20)"`
+	TargetID   uint
+	TargetName string `gorm:"type:varchar(255)"`
+	Detail     string `gorm:"type:text"`
+	PrevHash   string `gorm:"type:char(64);not null"`
+	Hash       string `gorm:"type:char(64);not null"`
+}
+
+func (auditLogV1) TableName() string {
+	return "audit_log"
+}
+
+type auditHeadV1 struct {
+	ID        uint
+	Hash      string `gorm:"type:char(64);not null"`
+	Count     uint   `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (auditHeadV1) TableName() string {
+	return "audit_head"
+}
+
+// userV2 20261019000003_user_sessions_revoked_at
+type userV2 struct {
+	UserV1            userV1 `gorm:"embedded"`
+	SessionsRevokedAt *time.Time
+}
+
+func (userV2) TableName() string {
+	return "user"
+}
+
+// userV3 20261019000004_user_locale
+type userV3 struct {
+	UserV2 userV2 `gorm:"embedded"`
+	Locale string `gorm:"type:varchar(10)"`
+}
+
+func (userV3) TableName() string {
+	return "user"
+}
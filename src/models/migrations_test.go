@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sqliteSchema 每个表的列及索引, 与列的顺序无关
+func sqliteSchema(t *testing.T) map[string][]string {
+	t.Helper()
+	var tables []string
+	err := db.Table("sqlite_master").Where("type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'schema_migrations%'").
+		Pluck("name", &tables).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := map[string][]string{}
+	for _, table := range tables {
+		var items []string
+		queryRows(t, fmt.Sprintf("PRAGMA table_info(%q)", table), func(rows *sql.Rows) {
+			var cid, notNull, pk int
+			var name, colType string
+			var dflt sql.NullString
+			if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+				t.Fatal(err)
+			}
+			items = append(items, fmt.Sprintf("column %s %s notnull=%d default=%s pk=%d", name, colType, notNull, dflt.String, pk))
+		})
+
+		var indexes []string
+		queryRows(t, fmt.Sprintf("PRAGMA index_list(%q)", table), func(rows *sql.Rows) {
+			var seq, unique, partial int
+			var name, origin string
+			if err := rows.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
+				t.Fatal(err)
+			}
+			indexes = append(indexes, fmt.Sprintf("%s %d %s", name, unique, origin))
+		})
+		for _, index := range indexes {
+			fields := strings.Fields(index)
+			var columns []string
+			queryRows(t, fmt.Sprintf("PRAGMA index_info(%q)", fields[0]), func(rows *sql.Rows) {
+				var seqno, cid int
+				var name string
+				if err := rows.Scan(&seqno, &cid, &name); err != nil {
+					t.Fatal(err)
+				}
+				columns = append(columns, name)
+			})
+			// unique/primary key 自动创建的索引名称带序号, 只比较列
+			if fields[2] != "c" {
+				fields[0] = "auto"
+			}
+			items = append(items, fmt.Sprintf("index %s unique=%s %s", fields[0], fields[1], strings.Join(columns, ",")))
+		}
+		sort.Strings(items)
+		schema[table] = items
+	}
+	return schema
+}
+
+func queryRows(t *testing.T, query string, scan func(rows *sql.Rows)) {
+	t.Helper()
+	rows, err := db.Raw(query).Rows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		scan(rows)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareSchema(t *testing.T, got, want map[string][]string) {
+	t.Helper()
+	if reflect.DeepEqual(got, want) {
+		return
+	}
+	for table, items := range want {
+		if !reflect.DeepEqual(got[table], items) {
+			t.Errorf("table %s:\n got  %v\n want %v", table, got[table], items)
+		}
+	}
+	for table := range got {
+		if _, ok := want[table]; !ok {
+			t.Errorf("unexpected table %s", table)
+		}
+	}
+}
+
+// 迁移得到的表结构与当前模型 AutoMigrate 的结果一致
+func TestMigrationsMatchModels(t *testing.T) {
+	openTestDB(t)
+	migrated := sqliteSchema(t)
+
+	for table := range migrated {
+		if err := db.DropTable(table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.AutoMigrate(&User{}, &Permission{}, &Group{}, &File{}, &Upload{}, &RecordHistory{}, &AuditLog{}, &AuditHead{}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareSchema(t, migrated, sqliteSchema(t))
+}
+
+func TestMigrateDown(t *testing.T) {
+	openTestDB(t)
+	latest := sqliteSchema(t)
+
+	if err := db.Create(&User{Username: "alice", Phone: "13800000000", Locale: "en-US"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count, err := MigrateDown(2); err != nil || count != 2 {
+		t.Fatalf("migrate down: %d %v", count, err)
+	}
+	for _, column := range []string{"locale", "sessions_revoked_at"} {
+		if db.Dialect().HasColumn("user", column) {
+			t.Errorf("column %s not dropped", column)
+		}
+	}
+	var user userV1
+	if err := db.Where("username = ?", "alice").First(&user).Error; err != nil || user.Phone != "13800000000" {
+		t.Errorf("user data lost after down: %+v %v", user, err)
+	}
+
+	if count, err := MigrateUp(); err != nil || count != 2 {
+		t.Fatalf("migrate up: %d %v", count, err)
+	}
+	compareSchema(t, sqliteSchema(t), latest)
+
+	if count, err := MigrateDown(len(migrations)); err != nil || count != len(migrations) {
+		t.Fatalf("migrate down all: %d %v", count, err)
+	}
+	if schema := sqliteSchema(t); len(schema) != 0 {
+		t.Errorf("tables left after down: %v", schema)
+	}
+}
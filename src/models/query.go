@@ -0,0 +1,223 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrInvalidQuery = errors.New("invalid list query")
+
+// 过滤操作符, 对应 ?col[op]=value
+var filterOps = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+	"in":   "IN",
+}
+
+var (
+	filterKeyRegexp = regexp.MustCompile(`^filter\[(\w+)\]$`)
+	opKeyRegexp     = regexp.MustCompile(`^(\w+)\[(\w+)\]$`)
+)
+
+// QueryFields 列表接口允许搜索/过滤/排序的字段白名单
+// 字段名会拼接进SQL, 只能使用白名单中的字段
+type QueryFields struct {
+	Search      []string
+	Filter      []string
+	Sort        []string
+	DefaultSort string // 未指定 sort 参数时使用, 格式同 sort 参数
+
+	Select        []string          // 可通过 fields 参数选择的字段, 敏感字段(如密码)不能加入
+	DefaultSelect []string          // 列表未指定 fields 时返回的字段, 为空时为 Select 全部
+	Include       map[string]string // include 参数名 -> 关联字段名
+}
+
+type Filter struct {
+	Column string
+	Op     string // filterOps 的 key
+	Value  interface{}
+}
+
+// ListQuery 列表查询条件
+type ListQuery struct {
+	Search  string
+	Filters []Filter
+	Orders  []string // 如 created_at DESC
+	Sparse
+}
+
+var UserQueryFields = QueryFields{
+	Search: []string{"username", "chinese_name", "phone"},
+	Filter: []string{"id", "username", "active", "superuser", "created_at", "updated_at"},
+	Sort:   []string{"id", "username", "created_at", "updated_at"},
+	Select: []string{"id", "username", "chinese_name", "active", "superuser", "phone", "avatar", "locale", "version",
+		"created_at", "updated_at", "deleted_at"},
+	DefaultSelect: []string{"id", "username", "chinese_name", "active", "superuser", "avatar",
+		"created_at", "updated_at", "deleted_at"},
+	Include: map[string]string{"groups": "Groups", "permissions": "Permissions"},
+}
+
+var GroupQueryFields = QueryFields{
+	Search:  []string{"name", "description"},
+	Filter:  []string{"id", "name"},
+	Sort:    []string{"id", "name"},
+	Select:  []string{"id", "name", "description", "version", "deleted_at"},
+	Include: map[string]string{"users": "Users", "permissions": "Permissions"},
+}
+
+var PermissionQueryFields = QueryFields{
+	Search:  []string{"name", "description"},
+	Filter:  []string{"id", "name"},
+	Sort:    []string{"id", "name"},
+	Select:  []string{"id", "name", "description", "version", "deleted_at"},
+	Include: map[string]string{"users": "Users", "groups": "Groups"},
+}
+
+var FileQueryFields = QueryFields{
+	Search:      []string{"name"},
+	Filter:      []string{"id", "owner_id", "mime_type", "size", "hash", "created_at"},
+	Sort:        []string{"id", "name", "size", "created_at"},
+	DefaultSort: "-id",
+}
+
+var HistoryQueryFields = QueryFields{
+	Filter:      []string{"revision", "action", "actor_id", "created_at"},
+	Sort:        []string{"revision", "created_at"},
+	DefaultSort: "-revision",
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse 解析查询参数, 其他参数(如 page)忽略
+// ?q=keyword 在 Search 字段中模糊搜索
+// ?filter[active]=true 等值过滤
+// ?created_at[gte]=2020-01-01 操作符过滤, 操作符见 filterOps, in 的值以逗号分隔
+// ?sort=-created_at,username 排序, - 表示倒序
+// ?fields=&include= 见 Sparse
+func (f QueryFields) Parse(values url.Values) (*ListQuery, error) {
+	query := &ListQuery{
+		Search: values.Get("q"),
+		Sparse: Sparse{Fields: splitParam(values.Get("fields")), Includes: splitParam(values.Get("include"))},
+	}
+
+	for key, vs := range values {
+		var column, op string
+		if m := filterKeyRegexp.FindStringSubmatch(key); m != nil {
+			column, op = m[1], "eq"
+		} else if m := opKeyRegexp.FindStringSubmatch(key); m != nil {
+			column, op = m[1], m[2]
+		} else {
+			continue
+		}
+		for _, v := range vs {
+			query.Filters = append(query.Filters, Filter{Column: column, Op: op, Value: filterValue(op, v)})
+		}
+	}
+
+	sort := values.Get("sort")
+	if sort == "" {
+		sort = f.DefaultSort
+	}
+	if sort != "" {
+		for _, col := range strings.Split(sort, ",") {
+			col = strings.TrimSpace(col)
+			if strings.HasPrefix(col, "-") {
+				query.Orders = append(query.Orders, strings.TrimPrefix(col, "-")+" DESC")
+			} else {
+				query.Orders = append(query.Orders, strings.TrimPrefix(col, "+")+" ASC")
+			}
+		}
+	}
+
+	return query, f.Check(query)
+}
+
+// Check 校验查询条件中的字段和操作符都在白名单中
+func (f QueryFields) Check(query *ListQuery) error {
+	if query.Search != "" && len(f.Search) == 0 {
+		return fmt.Errorf("%w: search not supported", ErrInvalidQuery)
+	}
+	for _, filter := range query.Filters {
+		if !contains(f.Filter, filter.Column) {
+			return fmt.Errorf("%w: can not filter by %s", ErrInvalidQuery, filter.Column)
+		}
+		if _, ok := filterOps[filter.Op]; !ok {
+			return fmt.Errorf("%w: unknown operator %s", ErrInvalidQuery, filter.Op)
+		}
+	}
+	for _, order := range query.Orders {
+		parts := strings.Fields(order)
+		if len(parts) != 2 || !contains(f.Sort, parts[0]) || (parts[1] != "ASC" && parts[1] != "DESC") {
+			return fmt.Errorf("%w: can not sort by %s", ErrInvalidQuery, order)
+		}
+	}
+	return f.CheckSparse(&query.Sparse)
+}
+
+func filterValue(op string, v string) interface{} {
+	switch {
+	case op == "in":
+		return strings.Split(v, ",")
+	case op == "like":
+		return "%" + v + "%"
+	case v == "true":
+		return true
+	case v == "false":
+		return false
+	}
+	return v
+}
+
+// DBSearch 在 columns 中模糊搜索 keyword, columns 需经过白名单校验
+func DBSearch(columns []string, keyword string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		var searchQS []string
+		var args []interface{}
+		for _, col := range columns {
+			searchQS = append(searchQS, fmt.Sprintf("%s LIKE ?", col))
+			args = append(args, "%"+keyword+"%")
+		}
+		qs := strings.Join(searchQS, " OR ")
+		return db.Where(qs, args...)
+	}
+}
+
+// DBFilter filters 需经过白名单校验
+func DBFilter(filters []Filter) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, filter := range filters {
+			op := filterOps[filter.Op]
+			if op == "IN" {
+				db = db.Where(fmt.Sprintf("%s IN (?)", filter.Column), filter.Value)
+			} else {
+				db = db.Where(fmt.Sprintf("%s %s ?", filter.Column, op), filter.Value)
+			}
+		}
+		return db
+	}
+}
+
+// DBOrder orderCols 需经过白名单校验
+func DBOrder(orderCols []string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		qs := strings.Join(orderCols, ",")
+		return db.Order(qs)
+	}
+}
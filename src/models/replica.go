@@ -0,0 +1,107 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+)
+
+// DBRole 连接角色, 写操作和事务使用主库, 读操作可使用从库
+type DBRole int
+
+const (
+	RolePrimary DBRole = iota
+	RoleReplica
+)
+
+type replica struct {
+	host    string
+	mu      sync.RWMutex
+	db      *gorm.DB // 连接失败时为 nil, 由健康检查重连, 通过 conn/setConn 读写
+	healthy int32
+	health  dbHealth
+}
+
+func (r *replica) conn() *gorm.DB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.db
+}
+
+func (r *replica) setConn(conn *gorm.DB) {
+	r.mu.Lock()
+	r.db = conn
+	r.mu.Unlock()
+}
+
+var (
+	replicas    []*replica
+	replicaNext uint32
+)
+
+// initReplicas 连接 DBReplicaHosts 中的从库, 连接失败的从库标记为不可用, 由健康检查恢复
+func initReplicas() {
+	if len(config.AppConfig.DBReplicaHosts) == 0 {
+		return
+	}
+	if config.AppConfig.DBType == DBTypeSQLite {
+		logrus.Warn("sqlite3 does not support replicas, DBReplicaHosts ignored")
+		return
+	}
+
+	for _, host := range config.AppConfig.DBReplicaHosts {
+		r := &replica{host: host}
+		conn, err := openDB(host)
+		if err != nil {
+			logrus.Error("connect replica error: ", host, " ", err)
+		} else {
+			r.setConn(conn)
+			r.healthy = 1
+		}
+		r.health.set(err)
+		replicas = append(replicas, r)
+	}
+}
+
+// checkReplicas 由 pinger 定时调用, 不可用的从库不再分配读请求
+func checkReplicas() {
+	for _, r := range replicas {
+		conn := r.conn()
+		if conn == nil {
+			var err error
+			if conn, err = openDB(r.host); err != nil {
+				r.health.set(err)
+				continue
+			}
+			r.setConn(conn)
+		}
+
+		err := conn.DB().Ping()
+		r.health.set(err)
+		healthy := int32(0)
+		if err == nil {
+			healthy = 1
+		}
+		if atomic.SwapInt32(&r.healthy, healthy) != healthy {
+			logrus.Warnf("replica %s healthy: %v", r.host, healthy == 1)
+		}
+	}
+}
+
+// GetDB 按角色获取连接
+// RoleReplica 轮询可用的从库, 没有配置从库或从库都不可用时使用主库
+func GetDB(role DBRole) *gorm.DB {
+	if role == RolePrimary || len(replicas) == 0 {
+		return db
+	}
+	for range replicas {
+		r := replicas[atomic.AddUint32(&replicaNext, 1)%uint32(len(replicas))]
+		if atomic.LoadInt32(&r.healthy) == 1 {
+			return r.conn()
+		}
+	}
+	return db
+}
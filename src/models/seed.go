@@ -0,0 +1,330 @@
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// Seed 声明式初始化数据, 按自然键(用户名/名称)幂等写入
+// 已存在的记录更新声明的字段, 关联关系只追加不删除
+type Seed struct {
+	Permissions []SeedPermission `yaml:"permissions"`
+	Groups      []SeedGroup      `yaml:"groups"`
+	Users       []SeedUser       `yaml:"users"`
+}
+
+type SeedPermission struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+type SeedGroup struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Permissions []string `yaml:"permissions"`
+}
+
+type SeedUser struct {
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"` // 只在创建时使用, 为空时使用 UserBasePassword
+	ChineseName string   `yaml:"chinese_name"`
+	Phone       string   `yaml:"phone"`
+	Active      *bool    `yaml:"active"`
+	Superuser   *bool    `yaml:"superuser"`
+	Groups      []string `yaml:"groups"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// Merge 合并另一份数据, 同一自然键后者覆盖前者
+func (s *Seed) Merge(other *Seed) {
+	s.Permissions = append(s.Permissions, other.Permissions...)
+	s.Groups = append(s.Groups, other.Groups...)
+	s.Users = append(s.Users, other.Users...)
+}
+
+// LoadSeedFile 读取 YAML 格式的初始化数据
+func LoadSeedFile(path string) (*Seed, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var seed Seed
+	if err = yaml.UnmarshalStrict(content, &seed); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &seed, nil
+}
+
+// LoadSeedDir 按文件名顺序读取目录中所有 .yaml/.yml 文件并合并
+func LoadSeedDir(dir string) (*Seed, error) {
+	if utils.IsNotExist(dir) {
+		return nil, fmt.Errorf("seed dir not exist: %s", dir)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		ext := strings.ToLower(filepath.Ext(file.Name()))
+		if !file.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+
+	seed := &Seed{}
+	for _, name := range names {
+		fileSeed, err := LoadSeedFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		seed.Merge(fileSeed)
+	}
+	return seed, nil
+}
+
+// SeedEnv 写入 SeedPath/<env> 目录中的初始化数据, 如 seeds/dev
+// 先写入权限系统必需的数据, 新数据库上也可以引用 administrator 组
+func SeedEnv(env string) error {
+	seed, err := LoadSeedDir(filepath.Join(config.AppConfig.SeedPath, env))
+	if err != nil {
+		return err
+	}
+	return WithTx(func(tx *gorm.DB) error {
+		if err := authSeed().ApplyTx(tx); err != nil {
+			return err
+		}
+		return seed.ApplyTx(tx)
+	})
+}
+
+// ApplySeed 在一个事务中写入, 任一记录失败时全部回滚
+func ApplySeed(seed *Seed) error {
+	return WithTx(seed.ApplyTx)
+}
+
+func (s *Seed) ApplyTx(tx *gorm.DB) error {
+	for _, p := range s.Permissions {
+		if _, err := seedPermission(tx, p); err != nil {
+			return err
+		}
+	}
+	for _, g := range s.Groups {
+		group, err := seedGroup(tx, g)
+		if err != nil {
+			return err
+		}
+		if group == nil {
+			continue
+		}
+		if err = seedAppend(tx, group, "Permissions", &Permission{}, g.Permissions); err != nil {
+			return err
+		}
+	}
+	for _, u := range s.Users {
+		user, err := seedUser(tx, u)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			continue
+		}
+		if err = seedAppend(tx, user, "Groups", &Group{}, u.Groups); err != nil {
+			return err
+		}
+		if err = seedAppend(tx, user, "Permissions", &Permission{}, u.Permissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedFind 按自然键查找, 包括已软删除的记录
+// 已删除的记录不会被恢复, 返回 deleted 为 true
+func seedFind(tx *gorm.DB, model interface{}, column string, value string) (found bool, deleted bool, err error) {
+	result := tx.Unscoped().Where(column+" = ?", value).First(model)
+	if result.RecordNotFound() {
+		return false, false, nil
+	}
+	if result.Error != nil {
+		return false, false, result.Error
+	}
+	deletedAt, _ := tx.NewScope(model).FieldByName("DeletedAt")
+	return true, deletedAt != nil && !deletedAt.IsBlank, nil
+}
+
+// valuesChanged 与记录当前的字段值比较, 有不同时返回 true
+func valuesChanged(tx *gorm.DB, model interface{}, values map[string]interface{}) bool {
+	scope := tx.NewScope(model)
+	for column, value := range values {
+		if field, ok := scope.FieldByName(column); !ok || !reflect.DeepEqual(field.Field.Interface(), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// seedUpdate 更新已存在记录的字段并递增版本, 字段都没有变化时不更新
+func seedUpdate(tx *gorm.DB, model interface{}, values map[string]interface{}) error {
+	if !valuesChanged(tx, model, values) {
+		return nil
+	}
+	if err := bumpVersion(tx, model, 0); err != nil {
+		return err
+	}
+	return tx.Model(model).Updates(values).Error
+}
+
+func seedPermission(tx *gorm.DB, p SeedPermission) (*Permission, error) {
+	if p.Name == "" {
+		return nil, fmt.Errorf("seed permission: name required")
+	}
+	var perm Permission
+	found, deleted, err := seedFind(tx, &perm, "name", p.Name)
+	switch {
+	case err != nil:
+		return nil, err
+	case deleted:
+		logrus.Warn("seed skip deleted permission: ", p.Name)
+		return nil, nil
+	case found:
+		return &perm, seedUpdate(tx, &perm, map[string]interface{}{"description": p.Description})
+	}
+	perm = Permission{Name: p.Name, Description: p.Description}
+	return &perm, perm.CreateTx(tx)
+}
+
+func seedGroup(tx *gorm.DB, g SeedGroup) (*Group, error) {
+	if g.Name == "" {
+		return nil, fmt.Errorf("seed group: name required")
+	}
+	var group Group
+	found, deleted, err := seedFind(tx, &group, "name", g.Name)
+	switch {
+	case err != nil:
+		return nil, err
+	case deleted:
+		logrus.Warn("seed skip deleted group: ", g.Name)
+		return nil, nil
+	case found:
+		return &group, seedUpdate(tx, &group, map[string]interface{}{"description": g.Description})
+	}
+	group = Group{Name: g.Name, Description: g.Description}
+	return &group, group.CreateTx(tx)
+}
+
+func seedUser(tx *gorm.DB, u SeedUser) (*User, error) {
+	if u.Username == "" {
+		return nil, fmt.Errorf("seed user: username required")
+	}
+	values := map[string]interface{}{}
+	if u.ChineseName != "" {
+		values["chinese_name"] = u.ChineseName
+	}
+	if u.Phone != "" {
+		values["phone"] = u.Phone
+	}
+	// 布尔字段零值不会被 Create 写入, 统一通过 Updates 设置
+	if u.Active != nil {
+		values["active"] = *u.Active
+	}
+	if u.Superuser != nil {
+		values["superuser"] = *u.Superuser
+	}
+
+	var user User
+	found, deleted, err := seedFind(tx, &user, "username", u.Username)
+	switch {
+	case err != nil:
+		return nil, err
+	case deleted:
+		logrus.Warn("seed skip deleted user: ", u.Username)
+		return nil, nil
+	case found:
+		return &user, seedUpdate(tx, &user, values)
+	}
+
+	user = User{Username: u.Username, Password: u.Password}
+	if user.Password == "" {
+		user.Password = config.AppConfig.UserBasePassword
+	}
+	if err = user.EncryptPassword(); err != nil {
+		return nil, err
+	}
+	if err = user.CreateTx(tx); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return &user, nil
+	}
+	return &user, tx.Model(&user).Updates(values).Error
+}
+
+// seedAppend 按名称追加关联, 已存在的关联不重复添加
+func seedAppend(tx *gorm.DB, owner interface{}, association string, target interface{}, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	column := "name"
+	if _, ok := target.(*User); ok {
+		column = "username"
+	}
+
+	var rows []struct {
+		ID   uint
+		Name string
+	}
+	err := tx.Model(target).Select("id, "+column+" AS name").Where(column+" IN (?)", names).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	// 名称可能重复, 也可能有同名的记录, 每个名称需对应唯一的记录
+	found := map[string][]uint{}
+	for _, row := range rows {
+		found[row.Name] = append(found[row.Name], row.ID)
+	}
+	var ids []uint
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		switch len(found[name]) {
+		case 0:
+			return fmt.Errorf("seed %s: %s not found", strings.ToLower(association), name)
+		case 1:
+			ids = append(ids, found[name][0])
+		default:
+			return fmt.Errorf("seed %s: %s is not unique", strings.ToLower(association), name)
+		}
+	}
+	if err := trackHistory(tx, owner); err != nil {
+		return err
+	}
+
+	var values []interface{}
+	for _, id := range ids {
+		switch target.(type) {
+		case *Group:
+			values = append(values, &Group{ID: id})
+		case *Permission:
+			values = append(values, &Permission{ID: id})
+		case *User:
+			values = append(values, &User{ID: id})
+		}
+	}
+	return tx.Model(owner).Association(association).Append(values...).Error
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/sulin2018/go-web-base/src/app/config"
+)
+
+// openTestDB 使用内存 sqlite 并执行所有迁移, 测试结束后关闭
+func openTestDB(t *testing.T) {
+	t.Helper()
+	old := config.AppConfig
+	config.AppConfig.DBType = DBTypeSQLite
+	config.AppConfig.DBPath = SQLiteMemory
+	config.AppConfig.DBMigrationPath = ""
+	config.AppConfig.AppRunMode = "test"
+
+	conn, err := openDB("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = conn
+	t.Cleanup(func() {
+		conn.Close()
+		config.AppConfig = old
+	})
+
+	if _, err = MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type seedState struct {
+	users, groups, permissions, histories         int
+	userGroups, userPermissions, groupPermissions int
+	versions                                      map[string]uint
+	passwords                                     map[string]string
+}
+
+func loadSeedState(t *testing.T) seedState {
+	t.Helper()
+	state := seedState{versions: map[string]uint{}, passwords: map[string]string{}}
+	counts := []struct {
+		table string
+		count *int
+	}{
+		{"user", &state.users},
+		{"group", &state.groups},
+		{"permission", &state.permissions},
+		{"record_history", &state.histories},
+		{"user_group", &state.userGroups},
+		{"user_permission", &state.userPermissions},
+		{"group_permission", &state.groupPermissions},
+	}
+	for _, c := range counts {
+		if err := db.Table(c.table).Count(c.count).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var users []*User
+	if err := db.Find(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		state.versions["user:"+u.Username] = u.Version
+		state.passwords[u.Username] = u.Password
+	}
+	var groups []*Group
+	if err := db.Find(&groups).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range groups {
+		state.versions["group:"+g.Name] = g.Version
+	}
+	return state
+}
+
+func TestApplySeedIdempotent(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.UserBasePassword = "base123456"
+
+	seed, err := LoadSeedDir("../../seeds/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitAuth()
+	if err = ApplySeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	first := loadSeedState(t)
+	if first.users != 4 || first.groups != 2 || first.permissions != 3 {
+		t.Fatalf("first apply: %d users, %d groups, %d permissions", first.users, first.groups, first.permissions)
+	}
+
+	InitAuth()
+	if err = ApplySeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	second := loadSeedState(t)
+
+	if second.users != first.users || second.groups != first.groups || second.permissions != first.permissions ||
+		second.userGroups != first.userGroups || second.userPermissions != first.userPermissions ||
+		second.groupPermissions != first.groupPermissions {
+		t.Errorf("second apply changed rows: %+v -> %+v", first, second)
+	}
+	if second.histories != first.histories {
+		t.Errorf("second apply wrote %d histories", second.histories-first.histories)
+	}
+	for key, version := range first.versions {
+		if second.versions[key] != version {
+			t.Errorf("%s version %d -> %d", key, version, second.versions[key])
+		}
+	}
+	for username, password := range first.passwords {
+		if second.passwords[username] != password {
+			t.Errorf("%s password changed", username)
+		}
+	}
+
+	var carol User
+	if err = db.Where("username = ?", "carol").First(&carol).Error; err != nil {
+		t.Fatal(err)
+	}
+	if carol.Active {
+		t.Error("carol should be inactive")
+	}
+	if !(&User{Username: "carol", Password: "carol123"}).CheckPassword() {
+		t.Error("carol password not set from seed")
+	}
+}
+
+// 新数据库上 dev 数据引用的 administrator 组由 SeedEnv 先写入
+func TestSeedEnvFreshDB(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.SeedPath = "../../seeds"
+	config.AppConfig.UserBasePassword = "base123456"
+
+	if err := SeedEnv("dev"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err := db.Table("user_group").Joins("JOIN `user` ON `user`.id = user_group.user_id").
+		Joins("JOIN `group` ON `group`.id = user_group.group_id").
+		Where("`user`.username = ?", "devadmin").Pluck("`group`.name", &names).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "administrator" {
+		t.Errorf("devadmin groups: %v", names)
+	}
+}
+
+func TestSeedAppendNames(t *testing.T) {
+	openTestDB(t)
+	config.AppConfig.UserBasePassword = "base123456"
+	for _, name := range []string{"staff", "dup", "dup"} {
+		if err := db.Create(&Group{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seed := &Seed{Users: []SeedUser{{Username: "alice", Groups: []string{"staff", "staff"}}}}
+	if err := ApplySeed(seed); err != nil {
+		t.Fatalf("duplicate names: %v", err)
+	}
+	for _, groups := range [][]string{{"dup"}, {"dup", "missing"}, {"staff", "missing"}} {
+		seed = &Seed{Users: []SeedUser{{Username: "bob", Groups: groups}}}
+		if err := ApplySeed(seed); err == nil {
+			t.Errorf("groups %v: expected error", groups)
+		}
+	}
+}
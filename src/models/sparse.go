@@ -0,0 +1,144 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Sparse 返回字段选择
+// ?fields=id,username 只返回指定字段, 字段需在 QueryFields.Select 白名单中
+// ?include=groups,permissions 批量预加载并嵌入关联, 关联需在 QueryFields.Include 中
+type Sparse struct {
+	Fields   []string
+	Includes []string
+}
+
+// ParseSparse 解析 fields/include 参数
+func (f QueryFields) ParseSparse(values url.Values) (*Sparse, error) {
+	sparse := &Sparse{
+		Fields:   splitParam(values.Get("fields")),
+		Includes: splitParam(values.Get("include")),
+	}
+	return sparse, f.CheckSparse(sparse)
+}
+
+// CheckSparse 校验字段和关联都在白名单中
+func (f QueryFields) CheckSparse(sparse *Sparse) error {
+	for _, field := range sparse.Fields {
+		if !contains(f.Select, field) {
+			return fmt.Errorf("%w: can not select %s", ErrInvalidQuery, field)
+		}
+	}
+	for _, include := range sparse.Includes {
+		if _, ok := f.Include[include]; !ok {
+			return fmt.Errorf("%w: can not include %s", ErrInvalidQuery, include)
+		}
+	}
+	return nil
+}
+
+func splitParam(param string) []string {
+	var list []string
+	for _, v := range strings.Split(param, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// DBSparse 限定查询字段并预加载关联, sparse 需经过白名单校验
+// 未指定 fields 时查询 Select 中的全部字段; 总是查询 id 及 extra 中的字段
+// 每个关联只有一条查询, 避免 N+1
+// model 为模型或模型切片指针, 用于查找关联
+func (f QueryFields) DBSparse(model interface{}, sparse *Sparse, extra ...string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if len(f.Select) != 0 {
+			columns := sparse.Fields
+			if len(columns) == 0 {
+				columns = f.Select
+			}
+			tx = tx.Select(withColumns(columns, append([]string{"id"}, extra...)...))
+		}
+		for _, include := range sparse.Includes {
+			association := f.Include[include]
+			tx = tx.Preload(association, preloadSelect(tx, model, association))
+		}
+		return tx
+	}
+}
+
+// ListSparse 列表未指定 fields 时使用 DefaultSelect, 为空时为 Select 全部
+// 返回时也只保留这些字段, 不输出未查询的字段及未加载的关联
+func (f QueryFields) ListSparse(sparse Sparse) *Sparse {
+	if len(sparse.Fields) == 0 {
+		sparse.Fields = f.DefaultSelect
+	}
+	if len(sparse.Fields) == 0 {
+		sparse.Fields = f.Select
+	}
+	return &sparse
+}
+
+func withColumns(columns []string, extra ...string) []string {
+	result := append([]string{}, columns...)
+	for _, col := range extra {
+		if !contains(result, col) {
+			result = append(result, col)
+		}
+	}
+	return result
+}
+
+// preloadSelect 预加载多对多关联时只查询关联模型 Select 白名单中的字段, 如不查询用户密码
+func preloadSelect(tx *gorm.DB, model interface{}, association string) func(*gorm.DB) *gorm.DB {
+	return func(preloadDB *gorm.DB) *gorm.DB {
+		var relation *gorm.Relationship
+		var fieldType reflect.Type
+		for _, field := range tx.NewScope(model).GetModelStruct().StructFields {
+			if field.Name == association {
+				relation, fieldType = field.Relationship, field.Struct.Type
+			}
+		}
+		if relation == nil || relation.JoinTableHandler == nil {
+			return preloadDB
+		}
+
+		target := reflect.New(indirectType(fieldType.Elem())).Interface()
+		fields := queryFieldsOf(target)
+		if fields == nil || len(fields.Select) == 0 {
+			return preloadDB
+		}
+
+		dialect := tx.Dialect()
+		table := quoteTable(tx, target)
+		var columns []string
+		for _, col := range fields.Select {
+			columns = append(columns, table+"."+dialect.Quote(col))
+		}
+		// 中间表中的外键用于把结果分配给对应的记录
+		joinTable := dialect.Quote(relation.JoinTableHandler.Table(tx))
+		for _, key := range relation.JoinTableHandler.SourceForeignKeys() {
+			columns = append(columns, joinTable+"."+dialect.Quote(key.DBName))
+		}
+		return preloadDB.Select(strings.Join(columns, ", "))
+	}
+}
+
+func queryFieldsOf(model interface{}) *QueryFields {
+	switch model.(type) {
+	case *User:
+		return &UserQueryFields
+	case *Group:
+		return &GroupQueryFields
+	case *Permission:
+		return &PermissionQueryFields
+	case *File:
+		return &FileQueryFields
+	}
+	return nil
+}
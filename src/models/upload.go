@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Upload 分片上传任务, 完成后生成 File
+type Upload struct {
+	ID        string    `gorm:"type:char(32);primaryKey" uri:"id" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OwnerID   uint      `gorm:"index;not null" json:"owner_id"`
+	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
+	Size      int64     `gorm:"not null" json:"size"`
+	Offset    int64     `gorm:"column:received;not null;default:0" description:"已接收字节数" json:"offset"`
+	Hash      string    `gorm:"type:char(64)" description:"客户端提供的整个文件sha256, 为空时不校验" json:"hash"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	FileID    uint      `description:"上传完成后对应的文件" json:"file_id"`
+}
+
+func (s *Upload) Create() error {
+	result := db.Create(s)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+func (s *Upload) Delete() error {
+	result := db.Delete(s)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+func (s *Upload) UpdateColumns(columns map[string]interface{}) error {
+	result := db.Model(s).Updates(columns)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+// ExpiredUploads 过期的上传任务
+func ExpiredUploads() ([]*Upload, error) {
+	var uploads []*Upload
+	result := db.Where("expires_at < ?", time.Now()).Find(&uploads)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return nil, result.Error
+	}
+	return uploads, nil
+}
@@ -1,24 +1,40 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrUsernameTaken = errors.New("username already taken")
+
+// PasswordMinLength 新密码最小长度
+const PasswordMinLength = 6
+
 type User struct {
 	ID          uint       `gorm:"primaryKey" uri:"id" json:"id"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
 	DeletedAt   *time.Time `gorm:"index" json:"deleted_at"`
 	Username    string     `gorm:"type:varchar(50);not null;unique" description:"用户名" json:"username"`
-	Password    string     `gorm:"type:varchar(100)" json:"password,omitempty"` // omitempty作用: 该字段为空时, 对象转json将忽略该字段 -字符: 可彻底忽略
+	Password    string     `gorm:"type:varchar(100)" json:"-" secret:"true"` // 敏感字段不输出到json, 返回数据见 presenter
 	ChineseName string     `gorm:"type:varchar(25)" description:"中文名" json:"chinese_name"`
 	// UserDN      string     `gorm:"type:varchar(100)" description:"LDAP DN" json:"user_dn"`
-	Active    bool   `gorm:"default:1" json:"active"`
-	Superuser bool   `gorm:"default:0" json:"superuser"`
+	Active    bool   `gorm:"default:true" json:"active"`
+	Superuser bool   `gorm:"default:false" json:"superuser"`
 	Phone     string `gorm:"type:varchar(20)" description:"手机" json:"phone"`
+	Version   uint   `gorm:"not null;default:1" description:"乐观锁版本" json:"version"`
+	Avatar    string `gorm:"type:varchar(64)" description:"头像key" json:"avatar"`
+	Locale    string `gorm:"type:varchar(10)" description:"语言, 为空时按请求或默认语言" json:"locale"`
+	// 删除后允许重用用户名时, 原用户名移到此处
+	DeletedUsername string `gorm:"type:varchar(50)" json:"-"`
+	// 在此之前登录的会话失效
+	SessionsRevokedAt *time.Time `json:"-"`
 
 	Permissions   []*Permission `gorm:"many2many:user_permission" json:"permissions"`
 	PermissionIds []uint        `gorm:"-" json:"permission_ids"`
@@ -27,9 +43,11 @@ type User struct {
 }
 
 type Permission struct {
-	ID          uint   `gorm:"primaryKey" uri:"id" json:"id"`
-	Name        string `gorm:"type:varchar(30);not null;unique" json:"name"`
-	Description string `gorm:"type:text" json:"description"`
+	ID          uint       `gorm:"primaryKey" uri:"id" json:"id"`
+	DeletedAt   *time.Time `gorm:"index" json:"deleted_at"`
+	Name        string     `gorm:"type:varchar(30);not null;unique" json:"name"`
+	Description string     `gorm:"type:text" json:"description"`
+	Version     uint       `gorm:"not null;default:1" description:"乐观锁版本" json:"version"`
 
 	Users    []*User  `gorm:"many2many:user_permission" json:"users"`
 	UserIds  []uint   `gorm:"-" json:"user_ids"`
@@ -38,9 +56,11 @@ type Permission struct {
 }
 
 type Group struct {
-	ID          uint   `gorm:"primaryKey" uri:"id" json:"id"`
-	Name        string `gorm:"type:varchar(30);not null" json:"name"`
-	Description string `gorm:"type:text" json:"description"`
+	ID          uint       `gorm:"primaryKey" uri:"id" json:"id"`
+	DeletedAt   *time.Time `gorm:"index" json:"deleted_at"`
+	Name        string     `gorm:"type:varchar(30);not null" json:"name"`
+	Description string     `gorm:"type:text" json:"description"`
+	Version     uint       `gorm:"not null;default:1" description:"乐观锁版本" json:"version"`
 
 	Permissions   []*Permission `gorm:"many2many:group_permission" json:"permissions"`
 	PermissionIds []uint        `gorm:"-" json:"permission_ids"`
@@ -48,6 +68,24 @@ type Group struct {
 	UserIds       []uint        `gorm:"-" json:"user_ids"`
 }
 
+// Versioned 带乐观锁版本的模型
+type Versioned interface {
+	GetVersion() uint
+	LoadAllAssociationIds(tx *gorm.DB) error
+}
+
+func (s *User) GetVersion() uint {
+	return s.Version
+}
+
+func (s *Group) GetVersion() uint {
+	return s.Version
+}
+
+func (s *Permission) GetVersion() uint {
+	return s.Version
+}
+
 // func (s *User) AfterFind(tx *gorm.DB) (err error) {
 // 	if s.Password != "" {
 // 		s.Password = ""
@@ -66,12 +104,23 @@ func (s *User) EncryptPassword() error {
 }
 
 func (s *User) SetPassword(tempPw string) error {
+	return s.SetPasswordTx(db, tempPw)
+}
+
+func (s *User) SetPasswordTx(tx *gorm.DB, tempPw string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(tempPw), bcrypt.DefaultCost)
 	if err != nil {
 		logrus.Error("set password error:", err)
 		return err
 	}
-	db.Model(s).Update("Password", string(hash))
+	result := tx.Model(s).Update("Password", string(hash))
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
@@ -80,7 +129,7 @@ func (s *User) CheckPassword() bool {
 		return false
 	}
 	var userPassword string
-	row := db.Table("user").Where("username = ?", s.Username).Select("password").Row()
+	row := db.Model(&User{}).Where("username = ?", s.Username).Select("password").Row()
 	err := row.Scan(&userPassword)
 	if err == nil {
 		err := bcrypt.CompareHashAndPassword([]byte(userPassword), []byte(s.Password))
@@ -98,6 +147,54 @@ func (s *User) CheckPassword() bool {
 	return false
 }
 
+// RevokeSessions 使该用户当前已登录的会话全部失效
+func (s *User) RevokeSessions() error {
+	return s.RevokeSessionsTx(db)
+}
+
+func (s *User) RevokeSessionsTx(tx *gorm.DB) error {
+	result := tx.Model(s).UpdateColumn("sessions_revoked_at", time.Now())
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s *User) SetAvatar(key string) error {
+	return s.SetAvatarTx(db, key)
+}
+
+// tx 可为携带操作人的连接, 如 GetRequestDB(c)
+func (s *User) SetAvatarTx(tx *gorm.DB, key string) error {
+	result := tx.Model(s).Update("Avatar", key)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+func (s *User) SetLocaleTx(tx *gorm.DB, locale string) error {
+	result := tx.Model(s).Update("Locale", locale)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+// AvatarInUse 头像按内容寻址, 相同图片的用户共用同一份文件
+func AvatarInUse(key string) bool {
+	var count uint
+	// 已删除用户可能被恢复, 同样保留其头像
+	db.Unscoped().Model(&User{}).Where("avatar = ?", key).Count(&count)
+	return count > 0
+}
+
 func (s *User) LoadPermAssociationIds() error {
 	var permissionIds []uint
 	result := db.Table("user_permission").Where("user_id = ?", s.ID).Pluck("permission_id", &permissionIds)
@@ -121,24 +218,29 @@ func (s *User) LoadGroupAssociationIds() error {
 	return nil
 }
 
-func (s *User) LoadAllAssociationIds() error {
-	var groupIds []uint
-	result := db.Table("user_group").Where("user_id = ?", s.ID).Pluck("group_id", &groupIds)
-	if result.Error != nil {
-		logrus.Error(result.Error)
-		return result.Error
+// tx 为查询使用的连接, 如 GetDB(RoleReplica)
+func (s *User) LoadAllAssociationIds(tx *gorm.DB) error {
+	var err error
+	if s.GroupIds, err = joinIds(tx, "user_group", "user_id", s.ID, "group_id", "group"); err != nil {
+		return err
 	}
-	s.GroupIds = groupIds
+	s.PermissionIds, err = joinIds(tx, "user_permission", "user_id", s.ID, "permission_id", "permission")
+	return err
+}
 
-	var permissionIds []uint
-	result = db.Table("user_permission").Where("user_id = ?", s.ID).Pluck("permission_id", &permissionIds)
+// joinIds 关联表 joinTable 中 key 为 id 的记录关联的 column, 不包括 target 表中已软删除的记录
+func joinIds(tx *gorm.DB, joinTable string, key string, id uint, column string, target string) ([]uint, error) {
+	quote := tx.Dialect().Quote
+	var ids []uint
+	result := tx.Table(joinTable).
+		Joins(fmt.Sprintf("JOIN %s ON %s.id = %s.%s", quote(target), quote(target), joinTable, column)).
+		Where(fmt.Sprintf("%s.%s = ? AND %s.deleted_at IS NULL", joinTable, key, quote(target)), id).
+		Pluck(joinTable+"."+column, &ids)
 	if result.Error != nil {
 		logrus.Error(result.Error)
-		logrus.Error(result.Error)
-		return result.Error
+		return nil, result.Error
 	}
-	s.PermissionIds = permissionIds
-	return nil
+	return ids, nil
 }
 
 func (s *User) LoadAllAssociations() error {
@@ -151,6 +253,10 @@ func (s *User) LoadAllAssociations() error {
 }
 
 func (s *User) Create() error {
+	return WithTx(s.CreateTx)
+}
+
+func (s *User) CreateTx(tx *gorm.DB) error {
 	// add relations
 	var groups []*Group
 	var permissions []*Permission
@@ -167,7 +273,7 @@ func (s *User) Create() error {
 	}
 	s.Permissions = permissions
 
-	result := db.Create(s)
+	result := tx.Create(s)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -175,14 +281,111 @@ func (s *User) Create() error {
 	return nil
 }
 
+// CreateValuesTx 创建后写入 values, 用于有默认值的字段写入零值, 如 active=false
+func (s *User) CreateValuesTx(tx *gorm.DB, values map[string]interface{}) error {
+	if err := s.CreateTx(tx); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	result := tx.Model(s).Updates(values)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+	}
+	return result.Error
+}
+
+// Delete 软删除, 保留关联关系以便恢复
 func (s *User) Delete() error {
+	return WithTx(s.DeleteTx)
+}
+
+func (s *User) DeleteTx(tx *gorm.DB) error {
 	if s.ID == 0 {
 		return nil
 	}
+
+	if !config.AppConfig.UserDeletedNameReserved {
+		// 释放用户名供新用户使用
+		var user User
+		result := tx.Select("id, username").First(&user, s.ID)
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+		result = tx.Model(&user).Updates(map[string]interface{}{
+			"username":         fmt.Sprintf("#deleted#%d", user.ID),
+			"deleted_username": user.Username,
+		})
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+	}
+
+	result := tx.Delete(s)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	return nil
+}
+
+// Restore 恢复软删除的用户, 原用户名已被占用时返回 ErrUsernameTaken
+func (s *User) Restore() error {
+	return WithTx(s.RestoreTx)
+}
+
+func (s *User) RestoreTx(tx *gorm.DB) error {
+	if err := loadDeleted(tx, s); err != nil {
+		return err
+	}
+
+	columns := map[string]interface{}{"deleted_at": nil}
+	if s.DeletedUsername != "" {
+		if !tx.Select("id").Where("username = ?", s.DeletedUsername).First(&User{}).RecordNotFound() {
+			return ErrUsernameTaken
+		}
+		columns["username"] = s.DeletedUsername
+		columns["deleted_username"] = ""
+	}
+
+	result := tx.Unscoped().Model(s).Updates(columns)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return result.Error
+	}
+	s.DeletedAt = nil
+	if s.DeletedUsername != "" {
+		s.Username, s.DeletedUsername = s.DeletedUsername, ""
+	}
+	return nil
+}
+
+// Purge 彻底删除已软删除的用户及其关联关系
+func (s *User) Purge() error {
+	return WithTx(s.PurgeTx)
+}
+
+func (s *User) PurgeTx(tx *gorm.DB) error {
+	if err := loadDeleted(tx, s); err != nil {
+		return err
+	}
+	if err := trackHistory(tx, s); err != nil {
+		return err
+	}
+
 	// clear relations
-	db.Model(s).Association("Groups").Clear()
-	db.Model(s).Association("Permissions").Clear()
-	result := db.Delete(s)
+	if err := tx.Model(s).Association("Groups").Clear().Error; err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err := tx.Model(s).Association("Permissions").Clear().Error; err != nil {
+		logrus.Error(err)
+		return err
+	}
+	result := tx.Unscoped().Delete(s)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -191,6 +394,20 @@ func (s *User) Delete() error {
 }
 
 func (s *User) Update() error {
+	return WithTx(s.UpdateTx)
+}
+
+func (s *User) UpdateTx(tx *gorm.DB) error {
+	return s.UpdateValuesTx(tx, s)
+}
+
+// UpdateValuesTx 关联id不为nil时替换关联, values 为 map 时零值也会写入, 为结构体时忽略零值
+func (s *User) UpdateValuesTx(tx *gorm.DB, values interface{}) error {
+	// s.Version 为客户端提交的版本
+	if err := bumpVersion(tx, s, s.Version); err != nil {
+		return err
+	}
+
 	// replace relations
 	var groups []*Group
 	var permissions []*Permission
@@ -200,7 +417,7 @@ func (s *User) Update() error {
 			group := Group{ID: groupId}
 			groups = append(groups, &group)
 		}
-		result := db.Model(s).Association("Groups").Replace(groups)
+		result := tx.Model(s).Association("Groups").Replace(groups)
 		if result.Error != nil {
 			logrus.Error(result.Error)
 			return result.Error
@@ -212,14 +429,14 @@ func (s *User) Update() error {
 			perm := Permission{ID: permId}
 			permissions = append(permissions, &perm)
 		}
-		result := db.Model(s).Association("Permissions").Replace(permissions)
+		result := tx.Model(s).Association("Permissions").Replace(permissions)
 		if result.Error != nil {
 			logrus.Error(result.Error)
 			return result.Error
 		}
 	}
 
-	result := db.Model(s).Updates(s)
+	result := tx.Model(s).Omit("version").Updates(values)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -227,23 +444,14 @@ func (s *User) Update() error {
 	return nil
 }
 
-func (s *Group) LoadAllAssociationIds() error {
-	var userIds []uint
-	result := db.Table("user_group").Where("group_id = ?", s.ID).Pluck("user_id", &userIds)
-	if result.Error != nil {
-		logrus.Error(result.Error)
-		return result.Error
-	}
-	s.UserIds = userIds
-
-	var permissionIds []uint
-	result = db.Table("group_permission").Where("group_id = ?", s.ID).Pluck("permission_id", &permissionIds)
-	if result.Error != nil {
-		logrus.Error(result.Error)
-		return result.Error
+// tx 为查询使用的连接, 如 GetDB(RoleReplica)
+func (s *Group) LoadAllAssociationIds(tx *gorm.DB) error {
+	var err error
+	if s.UserIds, err = joinIds(tx, "user_group", "group_id", s.ID, "user_id", "user"); err != nil {
+		return err
 	}
-	s.PermissionIds = permissionIds
-	return nil
+	s.PermissionIds, err = joinIds(tx, "group_permission", "group_id", s.ID, "permission_id", "permission")
+	return err
 }
 
 func (s *Group) LoadAllAssociations() error {
@@ -256,6 +464,10 @@ func (s *Group) LoadAllAssociations() error {
 }
 
 func (s *Group) Create() error {
+	return WithTx(s.CreateTx)
+}
+
+func (s *Group) CreateTx(tx *gorm.DB) error {
 	// add relations
 	var users []*User
 	var permissions []*Permission
@@ -272,7 +484,7 @@ func (s *Group) Create() error {
 	}
 	s.Permissions = permissions
 
-	result := db.Create(s)
+	result := tx.Create(s)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -280,14 +492,16 @@ func (s *Group) Create() error {
 	return nil
 }
 
+// Delete 软删除, 保留关联关系以便恢复
 func (s *Group) Delete() error {
+	return WithTx(s.DeleteTx)
+}
+
+func (s *Group) DeleteTx(tx *gorm.DB) error {
 	if s.ID == 0 {
 		return nil
 	}
-	// clear relations
-	db.Model(s).Association("Users").Clear()
-	db.Model(s).Association("Permissions").Clear()
-	result := db.Delete(s)
+	result := tx.Delete(s)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -296,6 +510,20 @@ func (s *Group) Delete() error {
 }
 
 func (s *Group) Update() error {
+	return WithTx(s.UpdateTx)
+}
+
+func (s *Group) UpdateTx(tx *gorm.DB) error {
+	return s.UpdateValuesTx(tx, s)
+}
+
+// UpdateValuesTx 关联id不为nil时替换关联, values 为 map 时零值也会写入, 为结构体时忽略零值
+func (s *Group) UpdateValuesTx(tx *gorm.DB, values interface{}) error {
+	// s.Version 为客户端提交的版本
+	if err := bumpVersion(tx, s, s.Version); err != nil {
+		return err
+	}
+
 	// replace relations
 	var users []*User
 	var permissions []*Permission
@@ -305,7 +533,7 @@ func (s *Group) Update() error {
 			user := User{ID: uerId}
 			users = append(users, &user)
 		}
-		result := db.Model(s).Association("Users").Replace(users)
+		result := tx.Model(s).Association("Users").Replace(users)
 		if result.Error != nil {
 			logrus.Error(result.Error)
 			return result.Error
@@ -317,14 +545,14 @@ func (s *Group) Update() error {
 			perm := Permission{ID: permId}
 			permissions = append(permissions, &perm)
 		}
-		result := db.Model(s).Association("Permissions").Replace(permissions)
+		result := tx.Model(s).Association("Permissions").Replace(permissions)
 		if result.Error != nil {
 			logrus.Error(result.Error)
 			return result.Error
 		}
 	}
 
-	result := db.Model(s).Updates(s)
+	result := tx.Model(s).Omit("version").Updates(values)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -343,23 +571,14 @@ func (s *Permission) LoadGroupAssociationIds() error {
 	return nil
 }
 
-func (s *Permission) LoadAllAssociationIds() error {
-	var groupIds []uint
-	result := db.Table("group_permission").Where("permission_id = ?", s.ID).Pluck("group_id", &groupIds)
-	if result.Error != nil {
-		logrus.Error(result.Error)
-		return result.Error
-	}
-	s.GroupIds = groupIds
-
-	var userIds []uint
-	result = db.Table("user_permission").Where("permission_id = ?", s.ID).Pluck("user_id", &userIds)
-	if result.Error != nil {
-		logrus.Error(result.Error)
-		return result.Error
+// tx 为查询使用的连接, 如 GetDB(RoleReplica)
+func (s *Permission) LoadAllAssociationIds(tx *gorm.DB) error {
+	var err error
+	if s.GroupIds, err = joinIds(tx, "group_permission", "permission_id", s.ID, "group_id", "group"); err != nil {
+		return err
 	}
-	s.UserIds = userIds
-	return nil
+	s.UserIds, err = joinIds(tx, "user_permission", "permission_id", s.ID, "user_id", "user")
+	return err
 }
 
 func (s *Permission) LoadAllAssociations() error {
@@ -372,6 +591,10 @@ func (s *Permission) LoadAllAssociations() error {
 }
 
 func (s *Permission) Create() error {
+	return WithTx(s.CreateTx)
+}
+
+func (s *Permission) CreateTx(tx *gorm.DB) error {
 	// add relations
 	var groups []*Group
 	var users []*User
@@ -388,7 +611,7 @@ func (s *Permission) Create() error {
 	}
 	s.Users = users
 
-	result := db.Create(s)
+	result := tx.Create(s)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -396,14 +619,16 @@ func (s *Permission) Create() error {
 	return nil
 }
 
+// Delete 软删除, 保留关联关系以便恢复
 func (s *Permission) Delete() error {
+	return WithTx(s.DeleteTx)
+}
+
+func (s *Permission) DeleteTx(tx *gorm.DB) error {
 	if s.ID == 0 {
 		return nil
 	}
-	// clear relations
-	db.Model(s).Association("Groups").Clear()
-	db.Model(s).Association("Users").Clear()
-	result := db.Delete(s)
+	result := tx.Delete(s)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -412,6 +637,20 @@ func (s *Permission) Delete() error {
 }
 
 func (s *Permission) Update() error {
+	return WithTx(s.UpdateTx)
+}
+
+func (s *Permission) UpdateTx(tx *gorm.DB) error {
+	return s.UpdateValuesTx(tx, s)
+}
+
+// UpdateValuesTx 关联id不为nil时替换关联, values 为 map 时零值也会写入, 为结构体时忽略零值
+func (s *Permission) UpdateValuesTx(tx *gorm.DB, values interface{}) error {
+	// s.Version 为客户端提交的版本
+	if err := bumpVersion(tx, s, s.Version); err != nil {
+		return err
+	}
+
 	// replace relations
 	var groups []*Group
 	var users []*User
@@ -421,7 +660,7 @@ func (s *Permission) Update() error {
 			group := Group{ID: groupId}
 			groups = append(groups, &group)
 		}
-		result := db.Model(s).Association("Groups").Replace(groups)
+		result := tx.Model(s).Association("Groups").Replace(groups)
 		if result.Error != nil {
 			logrus.Error(result.Error)
 			return result.Error
@@ -433,14 +672,14 @@ func (s *Permission) Update() error {
 			user := User{ID: userId}
 			users = append(users, &user)
 		}
-		result := db.Model(s).Association("Users").Replace(users)
+		result := tx.Model(s).Association("Users").Replace(users)
 		if result.Error != nil {
 			logrus.Error(result.Error)
 			return result.Error
 		}
 	}
 
-	result := db.Model(s).Updates(s)
+	result := tx.Model(s).Omit("version").Updates(values)
 	if result.Error != nil {
 		logrus.Error(result.Error)
 		return result.Error
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 已软删除的组/权限不出现在关联id中
+func TestLoadAllAssociationIdsSkipsDeleted(t *testing.T) {
+	openTestDB(t)
+
+	staff, old := &Group{Name: "staff"}, &Group{Name: "old"}
+	for _, group := range []*Group{staff, old} {
+		if err := db.Create(group).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := &User{Username: "alice", Groups: []*Group{staff, old}}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&Group{ID: old.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &User{ID: user.ID}
+	if err := loaded.LoadAllAssociationIds(db); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.GroupIds, []uint{staff.ID}) {
+		t.Errorf("user group ids: %v", loaded.GroupIds)
+	}
+	group := &Group{ID: old.ID}
+	if err := group.LoadAllAssociationIds(db); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(group.UserIds, []uint{user.ID}) {
+		t.Errorf("group user ids: %v", group.UserIds)
+	}
+}
+
+func TestUsersByNameGroupIds(t *testing.T) {
+	openTestDB(t)
+
+	staff, old := &Group{Name: "staff"}, &Group{Name: "old"}
+	for _, group := range []*Group{staff, old} {
+		if err := db.Create(group).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, user := range []*User{{Username: "alice", Groups: []*Group{staff, old}}, {Username: "bob"}} {
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete(&Group{ID: old.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := usersByName(db, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(users["alice"].GroupIds, []uint{staff.ID}) || users["bob"].GroupIds != nil {
+		t.Errorf("group ids: alice %v, bob %v", users["alice"].GroupIds, users["bob"].GroupIds)
+	}
+}
@@ -0,0 +1,451 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/apperr"
+	"github.com/sulin2018/go-web-base/src/app/config"
+)
+
+// 导入结果
+const (
+	ImportCreate    = "create"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+)
+
+// errImportRollback 整体导入时有行失败, 回滚事务
+var errImportRollback = errors.New("user import rollback")
+
+// 每批查询的用户数, 避免 IN 参数过多
+const userBatchSize = 500
+
+// UserImportRow 导入的一行数据, 按用户名匹配已有用户
+// 字段为空时不修改; Password 只在创建时使用, 为空时使用 UserBasePassword
+// Groups 为组名, nil 表示不修改, 空列表表示移出所有组
+type UserImportRow struct {
+	Row         int                 `json:"-"` // 源文件中的行号, 用于错误提示
+	Username    string              `json:"username" binding:"omitempty,username"`
+	Password    string              `json:"password"`
+	ChineseName string              `json:"chinese_name"`
+	Phone       string              `json:"phone" binding:"omitempty,phone"`
+	Active      *bool               `json:"active"`
+	Superuser   *bool               `json:"superuser"`
+	Groups      []string            `json:"groups"`
+	Invalid     []apperr.FieldError `json:"-"` // 解析文件时发现的错误, 如布尔值格式不对
+}
+
+// UserImportResult 每行的导入结果, Errors 或 Err 不为空时该行未写入
+// Errors 的 Message 由接口按请求的语言填写, Err 为写入时的数据库错误
+type UserImportResult struct {
+	Row      int                 `json:"row"`
+	Username string              `json:"username"`
+	Action   string              `json:"action,omitempty"`
+	ID       uint                `json:"id,omitempty"`
+	Errors   []apperr.FieldError `json:"errors,omitempty"`
+	Err      error               `json:"-"`
+
+	user     *User  // 已存在的用户, 创建时为 nil
+	groupIds []uint // 组名解析后的id, nil 表示不修改
+}
+
+// UserImportReport Applied 为 false 时没有写入任何数据(试运行或整体导入失败)
+type UserImportReport struct {
+	DryRun  bool                `json:"dry_run"`
+	Atomic  bool                `json:"atomic"`
+	Applied bool                `json:"applied"`
+	Total   int                 `json:"total"`
+	Created int                 `json:"created"`
+	Updated int                 `json:"updated"`
+	Failed  int                 `json:"failed"`
+	Rows    []*UserImportResult `json:"rows"`
+}
+
+func (s *UserImportResult) addError(field string, code string, params map[string]interface{}) {
+	s.Errors = append(s.Errors, apperr.FieldError{Field: field, Code: code, Params: params})
+}
+
+// values 需要写入的字段, 布尔字段零值不会被 Create 写入, 统一通过 Updates 设置
+func (s *UserImportRow) values() map[string]interface{} {
+	values := map[string]interface{}{}
+	if s.ChineseName != "" {
+		values["chinese_name"] = s.ChineseName
+	}
+	if s.Phone != "" {
+		values["phone"] = s.Phone
+	}
+	if s.Active != nil {
+		values["active"] = *s.Active
+	}
+	if s.Superuser != nil {
+		values["superuser"] = *s.Superuser
+	}
+	return values
+}
+
+// ImportUsers 批量创建或更新用户, conn 可为携带操作人的 GetRequestDB(c)
+// 先校验所有行(字段长度/文件内重复/组名/已删除用户占用的用户名), 再逐行写入
+// dryRun 只校验不写入, 已存在的用户比较字段得出 update/unchanged
+// atomic 时所有行在一个事务中写入, 任一行失败全部回滚; 否则每行单独提交, 失败的行不影响其他行
+// manageSuperuser 为 false 时不能修改已有的超级管理员, 该行报错
+func ImportUsers(conn *gorm.DB, rows []*UserImportRow, dryRun bool, atomic bool, manageSuperuser bool) (*UserImportReport, error) {
+	report := &UserImportReport{DryRun: dryRun, Atomic: atomic, Total: len(rows)}
+	if err := planUserImport(conn, rows, report, manageSuperuser); err != nil {
+		return nil, err
+	}
+	report.count()
+	if dryRun || (atomic && report.Failed != 0) {
+		return report, nil
+	}
+
+	if atomic {
+		err := WithTxFrom(conn, func(tx *gorm.DB) error {
+			for i, result := range report.Rows {
+				if err := importUser(tx, rows[i], result); err != nil {
+					result.Err = err
+					return errImportRollback
+				}
+			}
+			return nil
+		})
+		if err != nil && err != errImportRollback {
+			return nil, err
+		}
+		report.Applied = err == nil
+		report.count()
+		return report, nil
+	}
+
+	for i, result := range report.Rows {
+		if len(result.Errors) != 0 {
+			continue
+		}
+		result.Err = WithTxFrom(conn, func(tx *gorm.DB) error {
+			return importUser(tx, rows[i], result)
+		})
+	}
+	report.Applied = true
+	report.count()
+	return report, nil
+}
+
+func (s *UserImportReport) count() {
+	s.Created, s.Updated, s.Failed = 0, 0, 0
+	for _, result := range s.Rows {
+		switch {
+		case len(result.Errors) != 0, result.Err != nil:
+			s.Failed++
+		case !s.Applied:
+		case result.Action == ImportCreate:
+			s.Created++
+		case result.Action == ImportUpdate:
+			s.Updated++
+		}
+	}
+}
+
+// planUserImport 校验每一行并查出已存在的用户, 结果写入 report.Rows
+func planUserImport(tx *gorm.DB, rows []*UserImportRow, report *UserImportReport, manageSuperuser bool) error {
+	var usernames, groupNames []string
+	for _, row := range rows {
+		row.Username = strings.TrimSpace(row.Username)
+		if row.Username != "" {
+			usernames = append(usernames, row.Username)
+		}
+		groupNames = append(groupNames, row.Groups...)
+	}
+
+	groupIds, err := groupIdsByName(tx, groupNames)
+	if err != nil {
+		return err
+	}
+	users, err := usersByName(tx, usernames)
+	if err != nil {
+		return err
+	}
+
+	firstRow := map[string]int{}
+	for _, row := range rows {
+		result := &UserImportResult{Row: row.Row, Username: row.Username, Errors: row.Invalid}
+		report.Rows = append(report.Rows, result)
+
+		switch {
+		case row.Username == "":
+			result.addError("username", "required", nil)
+		case utf8.RuneCountInString(row.Username) > 50:
+			result.addError("username", "max", map[string]interface{}{"param": 50})
+		case firstRow[row.Username] != 0:
+			result.addError("username", "duplicate_row", map[string]interface{}{"row": firstRow[row.Username]})
+		default:
+			firstRow[row.Username] = row.Row
+		}
+		if utf8.RuneCountInString(row.ChineseName) > 25 {
+			result.addError("chinese_name", "max", map[string]interface{}{"param": 25})
+		}
+		if utf8.RuneCountInString(row.Phone) > 20 {
+			result.addError("phone", "max", map[string]interface{}{"param": 20})
+		}
+		if row.Password != "" && len(row.Password) < PasswordMinLength {
+			result.addError("password", "min", map[string]interface{}{"param": PasswordMinLength})
+		}
+
+		if row.Groups != nil {
+			result.groupIds = []uint{}
+			for _, name := range row.Groups {
+				switch ids := groupIds[name]; len(ids) {
+				case 0:
+					result.addError("groups", "group_not_found", map[string]interface{}{"name": name})
+				case 1:
+					result.groupIds = append(result.groupIds, ids[0])
+				default:
+					result.addError("groups", "group_not_unique", map[string]interface{}{"name": name})
+				}
+			}
+		}
+
+		user := users[row.Username]
+		switch {
+		case user == nil:
+			result.Action = ImportCreate
+		case user.DeletedAt != nil:
+			result.addError("username", "deleted_user", nil)
+		default:
+			result.user, result.ID = user, user.ID
+			result.Action = ImportUpdate
+			if !importChanged(tx, user, row.values(), result.groupIds) {
+				result.Action = ImportUnchanged
+			} else if user.Superuser && !manageSuperuser {
+				result.addError("username", "superuser_target", nil)
+			}
+		}
+	}
+	return nil
+}
+
+// groupIdsByName 组名不唯一, 同名的组都返回, 由调用方报错
+func groupIdsByName(tx *gorm.DB, names []string) (map[string][]uint, error) {
+	groupIds := map[string][]uint{}
+	for start := 0; start < len(names); start += userBatchSize {
+		end := start + userBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		var groups []*Group
+		result := tx.Select("id, name").Where("name IN (?)", names[start:end]).Find(&groups)
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return nil, result.Error
+		}
+		for _, group := range groups {
+			if !containsId(groupIds[group.Name], group.ID) {
+				groupIds[group.Name] = append(groupIds[group.Name], group.ID)
+			}
+		}
+	}
+	return groupIds, nil
+}
+
+// usersByName 包括已软删除的用户, 并加载组id
+func usersByName(tx *gorm.DB, usernames []string) (map[string]*User, error) {
+	users := map[string]*User{}
+	for start := 0; start < len(usernames); start += userBatchSize {
+		end := start + userBatchSize
+		if end > len(usernames) {
+			end = len(usernames)
+		}
+		var batch []*User
+		result := tx.Unscoped().Where("username IN (?)", usernames[start:end]).Find(&batch)
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return nil, result.Error
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		byId := map[uint]*User{}
+		var userIds []uint
+		for _, user := range batch {
+			byId[user.ID] = user
+			userIds = append(userIds, user.ID)
+			users[user.Username] = user
+		}
+		var links []struct {
+			UserID  uint
+			GroupID uint
+		}
+		// 已软删除的组不计入, 与 groupIdsByName 一致
+		group := tx.Dialect().Quote("group")
+		result = tx.Table("user_group").Select("user_group.user_id, user_group.group_id").
+			Joins(fmt.Sprintf("JOIN %s ON %s.id = user_group.group_id", group, group)).
+			Where(fmt.Sprintf("user_group.user_id IN (?) AND %s.deleted_at IS NULL", group), userIds).Scan(&links)
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return nil, result.Error
+		}
+		for _, link := range links {
+			user := byId[link.UserID]
+			user.GroupIds = append(user.GroupIds, link.GroupID)
+		}
+	}
+	return users, nil
+}
+
+func containsId(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// importChanged 字段或组与已有用户不同时返回 true
+func importChanged(tx *gorm.DB, user *User, values map[string]interface{}, groupIds []uint) bool {
+	if valuesChanged(tx, user, values) {
+		return true
+	}
+	if groupIds == nil {
+		return false
+	}
+	added, removed := diffIds(user.GroupIds, groupIds)
+	return len(added) != 0 || len(removed) != 0
+}
+
+// importUser 按 planUserImport 的结果写入一行
+func importUser(tx *gorm.DB, row *UserImportRow, result *UserImportResult) error {
+	values := row.values()
+	switch result.Action {
+	case ImportUnchanged:
+		return nil
+
+	case ImportCreate:
+		user := User{Username: row.Username, Password: row.Password, GroupIds: result.groupIds}
+		if user.Password == "" {
+			user.Password = config.AppConfig.UserBasePassword
+		}
+		if err := user.EncryptPassword(); err != nil {
+			return err
+		}
+		if err := user.CreateValuesTx(tx, values); err != nil {
+			return err
+		}
+		result.ID = user.ID
+		return nil
+	}
+
+	user := result.user
+	if err := bumpVersion(tx, user, 0); err != nil {
+		return err
+	}
+	if valuesChanged(tx, user, values) {
+		if err := tx.Model(user).Updates(values).Error; err != nil {
+			logrus.Error(err)
+			return err
+		}
+	}
+	if result.groupIds == nil {
+		return nil
+	}
+	if added, removed := diffIds(user.GroupIds, result.groupIds); len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	if err := trackHistory(tx, user); err != nil {
+		return err
+	}
+	var groups []*Group
+	for _, id := range result.groupIds {
+		groups = append(groups, &Group{ID: id})
+	}
+	if err := tx.Model(user).Association("Groups").Replace(groups).Error; err != nil {
+		logrus.Error(err)
+		return err
+	}
+	return nil
+}
+
+// EachUser 按 query 搜索/过滤/排序后逐个遍历用户, 用于导出
+// 按页查询, 每个用户附带组名; 不查询密码
+func EachUser(tx *gorm.DB, query *ListQuery, fn func(user *User, groups []string) error) error {
+	if err := UserQueryFields.Check(query); err != nil {
+		return err
+	}
+	scopes := []func(*gorm.DB) *gorm.DB{DBSelect(strings.Join(UserQueryFields.Select, ", "))}
+	if query.Search != "" {
+		scopes = append(scopes, DBSearch(UserQueryFields.Search, query.Search))
+	}
+	if len(query.Filters) != 0 {
+		scopes = append(scopes, DBFilter(query.Filters))
+	}
+	// 以 id 保证分页顺序稳定
+	scopes = append(scopes, DBOrder(append(append([]string{}, query.Orders...), "id ASC")))
+
+	for page := uint(1); ; page++ {
+		var users []*User
+		result := tx.Scopes(scopes...).Scopes(DBPage(page, userBatchSize)).Find(&users)
+		if result.Error != nil {
+			logrus.Error(result.Error)
+			return result.Error
+		}
+		groupNames, err := userGroupNames(tx, users)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err = fn(user, groupNames[user.ID]); err != nil {
+				return err
+			}
+		}
+		if len(users) < userBatchSize {
+			return nil
+		}
+	}
+}
+
+// userGroupNames 批量查询用户所在的组名, 不包括已删除的组
+func userGroupNames(tx *gorm.DB, users []*User) (map[uint][]string, error) {
+	groupNames := map[uint][]string{}
+	if len(users) == 0 {
+		return groupNames, nil
+	}
+	var userIds []uint
+	for _, user := range users {
+		userIds = append(userIds, user.ID)
+	}
+
+	var links []struct {
+		UserID  uint
+		GroupID uint
+	}
+	result := tx.Table("user_group").Select("user_id, group_id").Where("user_id IN (?)", userIds).Order("group_id").Scan(&links)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return nil, result.Error
+	}
+	var groupIds []uint
+	for _, link := range links {
+		groupIds = append(groupIds, link.GroupID)
+	}
+	var groups []*Group
+	if len(groupIds) != 0 {
+		if result = tx.Select("id, name").Where("id IN (?)", groupIds).Find(&groups); result.Error != nil {
+			logrus.Error(result.Error)
+			return nil, result.Error
+		}
+	}
+	names := map[uint]string{}
+	for _, group := range groups {
+		names[group.ID] = group.Name
+	}
+	for _, link := range links {
+		if name, ok := names[link.GroupID]; ok {
+			groupNames[link.UserID] = append(groupNames[link.UserID], name)
+		}
+	}
+	return groupNames, nil
+}
@@ -0,0 +1,75 @@
+package presenter
+
+import (
+	"time"
+
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// FileView 文件信息, 不含存储位置, 下载通过下载接口或签名地址
+type FileView struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OwnerID   uint      `json:"owner_id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Hash      string    `json:"hash"`
+	MimeType  string    `json:"mime_type"`
+}
+
+func File(file *models.File) *FileView {
+	if file == nil {
+		return nil
+	}
+	return &FileView{
+		ID:        file.ID,
+		CreatedAt: file.CreatedAt,
+		UpdatedAt: file.UpdatedAt,
+		OwnerID:   file.OwnerID,
+		Name:      file.Name,
+		Size:      file.Size,
+		Hash:      file.Hash,
+		MimeType:  file.MimeType,
+	}
+}
+
+func Files(files []*models.File) []*FileView {
+	views := make([]*FileView, 0, len(files))
+	for _, file := range files {
+		views = append(views, File(file))
+	}
+	return views
+}
+
+// UploadView 分片上传任务及进度
+type UploadView struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	OwnerID   uint      `json:"owner_id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Offset    int64     `description:"已接收字节数" json:"offset"`
+	Hash      string    `description:"客户端提供的整个文件sha256, 为空时不校验" json:"hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+	FileID    uint      `description:"上传完成后对应的文件" json:"file_id"`
+}
+
+func Upload(task *models.Upload) *UploadView {
+	if task == nil {
+		return nil
+	}
+	return &UploadView{
+		ID:        task.ID,
+		CreatedAt: task.CreatedAt,
+		UpdatedAt: task.UpdatedAt,
+		OwnerID:   task.OwnerID,
+		Name:      task.Name,
+		Size:      task.Size,
+		Offset:    task.Offset,
+		Hash:      task.Hash,
+		ExpiresAt: task.ExpiresAt,
+		FileID:    task.FileID,
+	}
+}
@@ -0,0 +1,123 @@
+package presenter
+
+import (
+	"time"
+
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// GroupBrief 组名称, 用于本人资料
+type GroupBrief struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// PermissionBrief 权限名称, 用于本人资料
+type PermissionBrief struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// GroupView 组, 嵌入的用户只返回公开资料, 嵌入的权限不再展开其关联
+type GroupView struct {
+	ID            uint              `json:"id"`
+	DeletedAt     *time.Time        `json:"deleted_at"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	Version       uint              `json:"version"`
+	Permissions   []*PermissionView `json:"permissions"`
+	PermissionIds []uint            `json:"permission_ids"`
+	Users         []*UserPublic     `json:"users"`
+	UserIds       []uint            `json:"user_ids"`
+}
+
+// PermissionView 权限, 嵌入的用户只返回公开资料, 嵌入的组不再展开其关联
+type PermissionView struct {
+	ID          uint          `json:"id"`
+	DeletedAt   *time.Time    `json:"deleted_at"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Version     uint          `json:"version"`
+	Users       []*UserPublic `json:"users"`
+	UserIds     []uint        `json:"user_ids"`
+	Groups      []*GroupView  `json:"groups"`
+	GroupIds    []uint        `json:"group_ids"`
+}
+
+func Group(group *models.Group) *GroupView {
+	view := groupView(group)
+	if view == nil {
+		return nil
+	}
+	view.Users = publicUsers(group.Users)
+	if group.Permissions != nil {
+		view.Permissions = make([]*PermissionView, 0, len(group.Permissions))
+		for _, permission := range group.Permissions {
+			view.Permissions = append(view.Permissions, permissionView(permission))
+		}
+	}
+	return view
+}
+
+func Groups(groups []*models.Group) []*GroupView {
+	views := make([]*GroupView, 0, len(groups))
+	for _, group := range groups {
+		views = append(views, Group(group))
+	}
+	return views
+}
+
+func Permission(permission *models.Permission) *PermissionView {
+	view := permissionView(permission)
+	if view == nil {
+		return nil
+	}
+	view.Users = publicUsers(permission.Users)
+	if permission.Groups != nil {
+		view.Groups = make([]*GroupView, 0, len(permission.Groups))
+		for _, group := range permission.Groups {
+			view.Groups = append(view.Groups, groupView(group))
+		}
+	}
+	return view
+}
+
+func Permissions(permissions []*models.Permission) []*PermissionView {
+	views := make([]*PermissionView, 0, len(permissions))
+	for _, permission := range permissions {
+		views = append(views, Permission(permission))
+	}
+	return views
+}
+
+// groupView 不含嵌入的关联
+func groupView(group *models.Group) *GroupView {
+	if group == nil {
+		return nil
+	}
+	return &GroupView{
+		ID:            group.ID,
+		DeletedAt:     group.DeletedAt,
+		Name:          group.Name,
+		Description:   group.Description,
+		Version:       group.Version,
+		PermissionIds: group.PermissionIds,
+		UserIds:       group.UserIds,
+	}
+}
+
+// permissionView 不含嵌入的关联
+func permissionView(permission *models.Permission) *PermissionView {
+	if permission == nil {
+		return nil
+	}
+	return &PermissionView{
+		ID:          permission.ID,
+		DeletedAt:   permission.DeletedAt,
+		Name:        permission.Name,
+		Description: permission.Description,
+		Version:     permission.Version,
+		UserIds:     permission.UserIds,
+		GroupIds:    permission.GroupIds,
+	}
+}
@@ -0,0 +1,73 @@
+// Package presenter 接口返回的数据, 按查看者输出不同字段
+// 模型不直接返回给客户端, 新增的字段需要在视图中显式加入才会输出
+package presenter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// Audience 数据的查看者
+type Audience int
+
+const (
+	Public Audience = iota // 其他用户, 只有公开资料
+	Self                   // 本人
+	Admin                  // 有 manage_user 权限的管理员
+)
+
+// Present 将模型转换为对应查看者的视图, data 可为模型指针或模型切片(的指针), 其他数据原样返回
+func Present(data interface{}, audience Audience) interface{} {
+	switch v := data.(type) {
+	case *models.User:
+		return User(v, audience)
+	case *[]*models.User:
+		return Users(*v, audience)
+	case []*models.User:
+		return Users(v, audience)
+	case *models.Group:
+		return Group(v)
+	case *[]*models.Group:
+		return Groups(*v)
+	case []*models.Group:
+		return Groups(v)
+	case *models.Permission:
+		return Permission(v)
+	case *[]*models.Permission:
+		return Permissions(*v)
+	case []*models.Permission:
+		return Permissions(v)
+	case *models.File:
+		return File(v)
+	case *[]*models.File:
+		return Files(*v)
+	case []*models.File:
+		return Files(v)
+	case *models.Upload:
+		return Upload(v)
+	}
+	return data
+}
+
+// 带 secret:"true" 标签的字段(密码/密钥等)不能出现在 JSON 中
+var secretModels = []interface{}{models.User{}, models.Permission{}, models.Group{}, models.File{}, models.Upload{}}
+
+// CheckSecrets 检查模型中的敏感字段都设置了 json:"-", 启动时调用
+func CheckSecrets() error {
+	for _, model := range secretModels {
+		t := reflect.TypeOf(model)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Tag.Get("secret") != "true" {
+				continue
+			}
+			if name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]; name != "-" {
+				return fmt.Errorf("secret field %s.%s must be tagged json:\"-\"", t.Name(), field.Name)
+			}
+		}
+	}
+	return nil
+}
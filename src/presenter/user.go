@@ -0,0 +1,146 @@
+package presenter
+
+import (
+	"time"
+
+	"github.com/sulin2018/go-web-base/src/models"
+)
+
+// UserPublic 其他用户可见的资料
+type UserPublic struct {
+	ID          uint   `json:"id"`
+	Username    string `json:"username"`
+	ChineseName string `json:"chinese_name"`
+	Avatar      string `json:"avatar"`
+}
+
+// UserSelf 本人的资料, 组及权限只返回名称
+type UserSelf struct {
+	UserPublic
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	Phone       string             `json:"phone"`
+	Active      bool               `json:"active"`
+	Superuser   bool               `json:"superuser"`
+	Locale      string             `json:"locale"`
+	Groups      []*GroupBrief      `json:"groups"`
+	Permissions []*PermissionBrief `json:"permissions"`
+}
+
+// UserAdmin 管理员查看的用户
+type UserAdmin struct {
+	ID            uint              `json:"id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	DeletedAt     *time.Time        `json:"deleted_at"`
+	Username      string            `json:"username"`
+	ChineseName   string            `json:"chinese_name"`
+	Active        bool              `json:"active"`
+	Superuser     bool              `json:"superuser"`
+	Phone         string            `json:"phone"`
+	Version       uint              `json:"version"`
+	Avatar        string            `json:"avatar"`
+	Locale        string            `json:"locale"`
+	Permissions   []*PermissionView `json:"permissions"`
+	PermissionIds []uint            `json:"permission_ids"`
+	Groups        []*GroupView      `json:"groups"`
+	GroupIds      []uint            `json:"group_ids"`
+}
+
+func NewUserPublic(user *models.User) *UserPublic {
+	if user == nil {
+		return nil
+	}
+	return &UserPublic{ID: user.ID, Username: user.Username, ChineseName: user.ChineseName, Avatar: user.Avatar}
+}
+
+func NewUserSelf(user *models.User) *UserSelf {
+	if user == nil {
+		return nil
+	}
+	view := &UserSelf{
+		UserPublic: *NewUserPublic(user),
+		CreatedAt:  user.CreatedAt,
+		UpdatedAt:  user.UpdatedAt,
+		Phone:      user.Phone,
+		Active:     user.Active,
+		Superuser:  user.Superuser,
+		Locale:     user.Locale,
+	}
+	for _, group := range user.Groups {
+		view.Groups = append(view.Groups, &GroupBrief{ID: group.ID, Name: group.Name})
+	}
+	for _, permission := range user.Permissions {
+		view.Permissions = append(view.Permissions, &PermissionBrief{ID: permission.ID, Name: permission.Name})
+	}
+	return view
+}
+
+func NewUserAdmin(user *models.User) *UserAdmin {
+	if user == nil {
+		return nil
+	}
+	view := &UserAdmin{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		DeletedAt:     user.DeletedAt,
+		Username:      user.Username,
+		ChineseName:   user.ChineseName,
+		Active:        user.Active,
+		Superuser:     user.Superuser,
+		Phone:         user.Phone,
+		Version:       user.Version,
+		Avatar:        user.Avatar,
+		Locale:        user.Locale,
+		PermissionIds: user.PermissionIds,
+		GroupIds:      user.GroupIds,
+	}
+	if user.Permissions != nil {
+		view.Permissions = Permissions(user.Permissions)
+	}
+	if user.Groups != nil {
+		view.Groups = Groups(user.Groups)
+	}
+	return view
+}
+
+// User 按查看者返回用户视图
+func User(user *models.User, audience Audience) interface{} {
+	switch audience {
+	case Admin:
+		return NewUserAdmin(user)
+	case Self:
+		return NewUserSelf(user)
+	}
+	return NewUserPublic(user)
+}
+
+func Users(users []*models.User, audience Audience) interface{} {
+	switch audience {
+	case Admin:
+		views := make([]*UserAdmin, 0, len(users))
+		for _, user := range users {
+			views = append(views, NewUserAdmin(user))
+		}
+		return views
+	case Self:
+		views := make([]*UserSelf, 0, len(users))
+		for _, user := range users {
+			views = append(views, NewUserSelf(user))
+		}
+		return views
+	}
+	return publicUsers(users)
+}
+
+func publicUsers(users []*models.User) []*UserPublic {
+	if users == nil {
+		return nil
+	}
+	views := make([]*UserPublic, 0, len(users))
+	for _, user := range users {
+		views = append(views, NewUserPublic(user))
+	}
+	return views
+}
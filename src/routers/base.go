@@ -2,6 +2,8 @@ package routers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sulin2018/go-web-base/src/app/config"
 	"github.com/sulin2018/go-web-base/src/controllers"
 	"github.com/sulin2018/go-web-base/src/middleware"
 )
@@ -12,17 +14,41 @@ var g *gin.Engine
 func InitGinEngine() *gin.Engine {
 	g = gin.New()
 	// g.Use(gin.Logger())
-	g.Use(gin.Recovery())
-
+	g.Use(middleware.RequestIDMiddleware())
 	g.Use(middleware.AppLogger())
+	g.Use(gin.CustomRecovery(middleware.RecoveryHandler))
+	g.Use(middleware.ErrorMiddleware())
+	g.NoRoute(middleware.NoRouteHandler)
 	g.Use(middleware.SessionMiddleware())
 	g.Use(middleware.CorsMiddleware())
+	g.Use(middleware.DBRoleMiddleware())
+
+	g.GET("/api/openapi.json", controllers.OpenAPIGet)
+	if config.AppConfig.AppRunMode == "dev" {
+		g.GET("/api/docs", controllers.APIDocsGet)
+	}
 
 	apiv1 = g.Group("/api/v1")
 	apiv1.GET("/ping", controllers.Ping)
 	apiv1.POST("/user/login", controllers.UserLogin)
+	apiv1.POST("/user/logout", controllers.UserLogout)
+	apiv1.PUT("/user/password", middleware.LoginPermissionMiddleware(), controllers.UserPasswordPut)
+	apiv1.PUT("/user/locale", middleware.LoginPermissionMiddleware(), controllers.UserLocalePut)
+	apiv1.GET("/avatar/:key/:size", controllers.AvatarGet)
+	apiv1.GET("/db/stats", middleware.SuperuserMiddleware(), controllers.DBStatsGet)
+	apiv1.GET("/audit", middleware.SuperuserMiddleware(), controllers.AuditsGet)
+	apiv1.GET("/audit/export", middleware.SuperuserMiddleware(), controllers.AuditExport)
+	apiv1.GET("/audit/verify", middleware.SuperuserMiddleware(), controllers.AuditVerify)
 
 	AddUserV1Router()
+	AddFileV1Router()
+
+	// 路由注册完成后生成接口文档
+	doc, problems := BuildAPIDoc(g)
+	for _, problem := range problems {
+		logrus.Warn("openapi: ", problem)
+	}
+	controllers.SetAPIDoc(doc)
 
 	return g
 }
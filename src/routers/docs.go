@@ -0,0 +1,250 @@
+package routers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/app/config"
+	"github.com/sulin2018/go-web-base/src/app/openapi"
+	"github.com/sulin2018/go-web-base/src/dto"
+	"github.com/sulin2018/go-web-base/src/models"
+	"github.com/sulin2018/go-web-base/src/presenter"
+)
+
+// 新增路由时需在 routeDocs 中添加说明, 否则 TestAPIDocCoversRoutes 及 `openapi check` 命令失败
+
+const (
+	manageUser = "manage_user"
+	login      = openapi.AccessLogin
+	superuser  = openapi.AccessSuperuser
+	public     = openapi.AccessPublic
+)
+
+var ifMatch = openapi.Param{Name: "If-Match", Required: true, Description: "记录的版本, 即详情返回的 ETag"}
+
+var listDeleted = openapi.Param{Name: "deleted", Type: "boolean", Description: "true 时只查询已删除的记录"}
+
+var batchUpdated = openapi.Param{Name: "updated", Type: "integer", Description: "实际修改的数量"}
+
+// membership 单个关联修改后返回的当前关联id, 见 models.Membership
+func membership(key string) *openapi.Schema {
+	return &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+		"id":      {Type: "integer"},
+		"version": {Type: "integer"},
+		key:       {Type: "array", Items: &openapi.Schema{Type: "integer"}},
+	}}
+}
+
+var signedURL = &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+	"url":        {Type: "string"},
+	"expires_at": {Type: "string", Format: "date-time"},
+}}
+
+var localeResult = &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+	"locale":  {Type: "string"},
+	"locales": {Type: "array", Items: &openapi.Schema{Type: "string"}},
+}}
+
+var avatarResult = &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+	"avatar": {Type: "string", Description: "头像key, 图片地址为 /api/v1/avatar/{key}/{size}"},
+}}
+
+var routeDocs = []openapi.Route{
+	{Method: http.MethodGet, Path: "/api/openapi.json", Tag: "system", Summary: "OpenAPI 文档", Access: public,
+		Produces: []string{"application/json"}},
+	{Method: http.MethodGet, Path: "/api/v1/ping", Tag: "system", Summary: "健康检查", Access: public},
+	{Method: http.MethodGet, Path: "/api/v1/db/stats", Tag: "system", Summary: "数据库连接状态", Access: superuser,
+		Response: models.DBStatus{}, ResponseArr: true},
+
+	// 登录用户
+	{Method: http.MethodPost, Path: "/api/v1/user/login", Tag: "auth", Summary: "登录", Access: public,
+		Request: dto.UserLogin{}, Response: presenter.UserSelf{}},
+	{Method: http.MethodPost, Path: "/api/v1/user/logout", Tag: "auth", Summary: "退出登录", Access: public,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPut, Path: "/api/v1/user/password", Tag: "auth", Summary: "修改自己的密码", Access: login,
+		Request: dto.PasswordChange{}, Status: http.StatusNoContent},
+	{Method: http.MethodPut, Path: "/api/v1/user/locale", Tag: "auth", Summary: "设置自己的语言", Access: login,
+		Request: dto.UserLocale{}, Response: localeResult},
+	{Method: http.MethodGet, Path: "/api/v1/avatar/:key/:size", Tag: "user", Summary: "头像图片", Access: public,
+		Description: "内容不变, 可长期缓存", Produces: []string{"image/png"}},
+
+	// 审计
+	{Method: http.MethodGet, Path: "/api/v1/audit", Tag: "audit", Summary: "审计日志列表", Access: superuser,
+		List: &models.AuditQueryFields, Response: models.AuditLog{}},
+	{Method: http.MethodGet, Path: "/api/v1/audit/export", Tag: "audit", Summary: "导出审计日志", Access: superuser,
+		Description: "过滤参数同列表", Query: []openapi.Param{{Name: "format", Enum: []string{"csv", "jsonl"}}},
+		Produces: []string{"text/csv", "application/x-ndjson"}},
+	{Method: http.MethodGet, Path: "/api/v1/audit/verify", Tag: "audit", Summary: "校验审计日志 Hash 链", Access: superuser,
+		Response: models.AuditVerify{}},
+
+	// 用户
+	{Method: http.MethodGet, Path: "/api/v1/user/:id", Tag: "user", Summary: "用户详情", Access: manageUser,
+		Response: presenter.UserAdmin{}, Query: sparseParams(models.UserQueryFields)},
+	{Method: http.MethodPost, Path: "/api/v1/user", Tag: "user", Summary: "新增用户", Access: manageUser,
+		Description: "superuser 只有超级管理员可以设置", Request: dto.UserCreate{}, Status: http.StatusCreated, Response: presenter.UserAdmin{}},
+	{Method: http.MethodPatch, Path: "/api/v1/user/:id", Tag: "user", Summary: "修改用户", Access: manageUser,
+		Headers: []openapi.Param{ifMatch}, Request: dto.UserPatch{}, Response: presenter.UserAdmin{}},
+	{Method: http.MethodDelete, Path: "/api/v1/user/:id", Tag: "user", Summary: "删除用户", Access: manageUser,
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/v1/users", Tag: "user", Summary: "用户列表", Access: manageUser,
+		List: &models.UserQueryFields, Query: []openapi.Param{listDeleted}, Response: presenter.UserAdmin{}},
+	{Method: http.MethodPost, Path: "/api/v1/users/import", Tag: "user", Summary: "批量导入用户", Access: manageUser,
+		Description: "文件通过表单字段 file 上传或直接作为请求体, 第一行为列名; groups 列为空时不修改, 为 - 时移出所有组",
+		Query: []openapi.Param{
+			{Name: "format", Enum: []string{"csv", "xlsx", "json"}, Description: "未指定时按文件扩展名或 Content-Type 判断"},
+			{Name: "dry_run", Type: "boolean", Description: "只校验不写入"},
+			{Name: "atomic", Type: "boolean", Description: "任一行失败时全部不写入"},
+		},
+		FormFile: "file", RawBody: []string{"text/csv", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/json"},
+		Response: models.UserImportReport{}},
+	{Method: http.MethodGet, Path: "/api/v1/users/export", Tag: "user", Summary: "导出用户", Access: manageUser,
+		Description: "过滤参数同列表, 非超级管理员导出时 superuser 列为空", Query: []openapi.Param{{Name: "format", Enum: []string{"csv", "xlsx", "json"}}},
+		Produces: []string{"text/csv", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/json"}},
+	{Method: http.MethodPost, Path: "/api/v1/users/activate", Tag: "user", Summary: "批量启用用户", Access: manageUser,
+		Request: dto.BatchIds{}, Response: models.BatchResult{}, ResponseArr: true, More: []openapi.Param{batchUpdated}},
+	{Method: http.MethodPost, Path: "/api/v1/users/deactivate", Tag: "user", Summary: "批量禁用用户", Access: manageUser,
+		Request: dto.BatchIds{}, Response: models.BatchResult{}, ResponseArr: true, More: []openapi.Param{batchUpdated}},
+	{Method: http.MethodPost, Path: "/api/v1/user/:id/restore", Tag: "user", Summary: "恢复已删除的用户", Access: manageUser,
+		Response: presenter.UserAdmin{}},
+	{Method: http.MethodDelete, Path: "/api/v1/user/:id/purge", Tag: "user", Summary: "彻底删除用户", Access: superuser,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/v1/user/:id/avatar", Tag: "user", Summary: "上传头像", Access: manageUser,
+		FormFile: "avatar", Response: avatarResult},
+	{Method: http.MethodDelete, Path: "/api/v1/user/:id/avatar", Tag: "user", Summary: "删除头像", Access: manageUser,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/v1/user/:id/password", Tag: "user", Summary: "重置用户密码", Access: manageUser,
+		Request: dto.PasswordReset{}, Status: http.StatusNoContent},
+	{Method: http.MethodDelete, Path: "/api/v1/user/:id/sessions", Tag: "user", Summary: "注销用户的所有会话", Access: manageUser,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/v1/user/:id/groups/:gid", Tag: "user", Summary: "将用户加入组", Access: manageUser,
+		Response: membership("group_ids")},
+	{Method: http.MethodDelete, Path: "/api/v1/user/:id/groups/:gid", Tag: "user", Summary: "将用户移出组", Access: manageUser,
+		Response: membership("group_ids")},
+	{Method: http.MethodPost, Path: "/api/v1/user/:id/permissions/:pid", Tag: "user", Summary: "授予用户权限", Access: manageUser,
+		Response: membership("permission_ids")},
+	{Method: http.MethodDelete, Path: "/api/v1/user/:id/permissions/:pid", Tag: "user", Summary: "撤销用户权限", Access: manageUser,
+		Response: membership("permission_ids")},
+	{Method: http.MethodGet, Path: "/api/v1/user/:id/history", Tag: "user", Summary: "用户变更历史", Access: manageUser,
+		List: &models.HistoryQueryFields, Response: models.RecordHistory{}},
+	{Method: http.MethodPost, Path: "/api/v1/user/:id/history/:revision/revert", Tag: "user", Summary: "用户回滚到指定版本", Access: manageUser,
+		Description: "密码及 superuser 不回滚", Response: presenter.UserAdmin{}},
+
+	// 组
+	{Method: http.MethodGet, Path: "/api/v1/group/:id", Tag: "group", Summary: "组详情", Access: manageUser,
+		Response: presenter.GroupView{}, Query: sparseParams(models.GroupQueryFields)},
+	{Method: http.MethodPatch, Path: "/api/v1/group/:id", Tag: "group", Summary: "修改组", Access: manageUser,
+		Headers: []openapi.Param{ifMatch}, Request: dto.GroupPatch{}, Response: presenter.GroupView{}},
+	{Method: http.MethodDelete, Path: "/api/v1/group/:id", Tag: "group", Summary: "删除组", Access: manageUser,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPut, Path: "/api/v1/group/:id", Tag: "group", Summary: "全量更新组", Access: manageUser,
+		Headers: []openapi.Param{ifMatch}, Request: dto.GroupPut{}, Response: presenter.GroupView{}},
+	{Method: http.MethodPost, Path: "/api/v1/group", Tag: "group", Summary: "新增组", Access: manageUser,
+		Request: dto.GroupCreate{}, Status: http.StatusCreated, Response: presenter.GroupView{}},
+	{Method: http.MethodGet, Path: "/api/v1/groups", Tag: "group", Summary: "组列表", Access: manageUser,
+		List: &models.GroupQueryFields, Query: []openapi.Param{listDeleted}, Response: presenter.GroupView{}},
+	{Method: http.MethodPost, Path: "/api/v1/group/:id/users", Tag: "group", Summary: "批量添加组成员", Access: manageUser,
+		Request: dto.BatchIds{}, Response: models.BatchResult{}, ResponseArr: true, More: []openapi.Param{batchUpdated}},
+	{Method: http.MethodDelete, Path: "/api/v1/group/:id/users", Tag: "group", Summary: "批量移除组成员", Access: manageUser,
+		Request: dto.BatchIds{}, Response: models.BatchResult{}, ResponseArr: true, More: []openapi.Param{batchUpdated}},
+	{Method: http.MethodPost, Path: "/api/v1/group/:id/users/:uid", Tag: "group", Summary: "添加组成员", Access: manageUser,
+		Response: membership("user_ids")},
+	{Method: http.MethodDelete, Path: "/api/v1/group/:id/users/:uid", Tag: "group", Summary: "移除组成员", Access: manageUser,
+		Response: membership("user_ids")},
+	{Method: http.MethodPost, Path: "/api/v1/group/:id/permissions/:pid", Tag: "group", Summary: "授予组权限", Access: manageUser,
+		Response: membership("permission_ids")},
+	{Method: http.MethodDelete, Path: "/api/v1/group/:id/permissions/:pid", Tag: "group", Summary: "撤销组权限", Access: manageUser,
+		Response: membership("permission_ids")},
+	{Method: http.MethodPost, Path: "/api/v1/group/:id/restore", Tag: "group", Summary: "恢复已删除的组", Access: manageUser,
+		Description: "同时恢复其成员和权限", Response: presenter.GroupView{}},
+	{Method: http.MethodGet, Path: "/api/v1/group/:id/history", Tag: "group", Summary: "组变更历史", Access: manageUser,
+		List: &models.HistoryQueryFields, Response: models.RecordHistory{}},
+	{Method: http.MethodPost, Path: "/api/v1/group/:id/history/:revision/revert", Tag: "group", Summary: "组回滚到指定版本", Access: manageUser,
+		Response: presenter.GroupView{}},
+
+	// 权限
+	{Method: http.MethodGet, Path: "/api/v1/permission/:id", Tag: "permission", Summary: "权限详情", Access: manageUser,
+		Response: presenter.PermissionView{}, Query: sparseParams(models.PermissionQueryFields)},
+	{Method: http.MethodPatch, Path: "/api/v1/permission/:id", Tag: "permission", Summary: "修改权限", Access: manageUser,
+		Headers: []openapi.Param{ifMatch}, Request: dto.PermissionPatch{}, Response: presenter.PermissionView{}},
+	{Method: http.MethodDelete, Path: "/api/v1/permission/:id", Tag: "permission", Summary: "删除权限", Access: manageUser,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPost, Path: "/api/v1/permission", Tag: "permission", Summary: "新增权限", Access: manageUser,
+		Request: dto.PermissionCreate{}, Status: http.StatusCreated, Response: presenter.PermissionView{}},
+	{Method: http.MethodGet, Path: "/api/v1/permissions", Tag: "permission", Summary: "权限列表", Access: manageUser,
+		List: &models.PermissionQueryFields, Query: []openapi.Param{listDeleted}, Response: presenter.PermissionView{}},
+	{Method: http.MethodPost, Path: "/api/v1/permission/:id/groups", Tag: "permission", Summary: "将权限批量授予组", Access: manageUser,
+		Request: dto.BatchIds{}, Response: models.BatchResult{}, ResponseArr: true, More: []openapi.Param{batchUpdated}},
+	{Method: http.MethodDelete, Path: "/api/v1/permission/:id/groups", Tag: "permission", Summary: "批量撤销组的权限", Access: manageUser,
+		Request: dto.BatchIds{}, Response: models.BatchResult{}, ResponseArr: true, More: []openapi.Param{batchUpdated}},
+	{Method: http.MethodPost, Path: "/api/v1/permission/:id/restore", Tag: "permission", Summary: "恢复已删除的权限", Access: manageUser,
+		Response: presenter.PermissionView{}},
+	{Method: http.MethodGet, Path: "/api/v1/permission/:id/history", Tag: "permission", Summary: "权限变更历史", Access: manageUser,
+		List: &models.HistoryQueryFields, Response: models.RecordHistory{}},
+	{Method: http.MethodPost, Path: "/api/v1/permission/:id/history/:revision/revert", Tag: "permission", Summary: "权限回滚到指定版本", Access: manageUser,
+		Response: presenter.PermissionView{}},
+
+	// 文件
+	{Method: http.MethodGet, Path: "/api/v1/storage", Tag: "file", Summary: "签名地址下载", Access: public,
+		Description: "签名地址自带鉴权, 由 /file/{id}/url 生成",
+		Query:       []openapi.Param{{Name: "key", Required: true}, {Name: "expires", Required: true}, {Name: "sign", Required: true}},
+		Produces:    []string{"application/octet-stream"}},
+	{Method: http.MethodPost, Path: "/api/v1/file", Tag: "file", Summary: "上传文件", Access: login,
+		FormFile: "file", Status: http.StatusCreated, Response: presenter.FileView{}},
+	{Method: http.MethodGet, Path: "/api/v1/file/:id", Tag: "file", Summary: "文件详情", Access: login,
+		Response: presenter.FileView{}},
+	{Method: http.MethodGet, Path: "/api/v1/file/:id/download", Tag: "file", Summary: "下载文件", Access: login,
+		Produces: []string{"application/octet-stream"}},
+	{Method: http.MethodGet, Path: "/api/v1/file/:id/url", Tag: "file", Summary: "临时下载地址", Access: login,
+		Description: "可交给无登录态的客户端使用", Response: signedURL},
+	{Method: http.MethodDelete, Path: "/api/v1/file/:id", Tag: "file", Summary: "删除文件", Access: login,
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/v1/files", Tag: "file", Summary: "文件列表", Access: login,
+		List: &models.FileQueryFields, Response: presenter.FileView{}},
+
+	// 分片上传
+	{Method: http.MethodPost, Path: "/api/v1/upload", Tag: "upload", Summary: "创建分片上传任务", Access: login,
+		Request: dto.UploadCreate{}, Status: http.StatusCreated, Response: presenter.UploadView{}},
+	{Method: http.MethodGet, Path: "/api/v1/upload/:id", Tag: "upload", Summary: "查询上传进度", Access: login,
+		Description: "断点续传时从返回的 offset 继续", PathTypes: map[string]string{"id": "string"}, Response: presenter.UploadView{}},
+	{Method: http.MethodPatch, Path: "/api/v1/upload/:id", Tag: "upload", Summary: "上传分片", Access: login,
+		PathTypes: map[string]string{"id": "string"},
+		Headers: []openapi.Param{
+			{Name: "Upload-Offset", Type: "integer", Required: true, Description: "分片起始位置"},
+			{Name: "Upload-Checksum", Description: "分片的sha256"},
+		},
+		RawBody: []string{"application/offset+octet-stream"}, Response: presenter.UploadView{}},
+	{Method: http.MethodDelete, Path: "/api/v1/upload/:id", Tag: "upload", Summary: "取消上传", Access: login,
+		PathTypes: map[string]string{"id": "string"}, Status: http.StatusNoContent},
+}
+
+// 开发模式下注册的路由
+var devRouteDocs = []openapi.Route{
+	{Method: http.MethodGet, Path: "/api/docs", Tag: "system", Summary: "接口文档页面", Access: public,
+		Produces: []string{"text/html"}},
+}
+
+// sparseParams 详情接口的 fields/include 参数
+func sparseParams(fields models.QueryFields) []openapi.Param {
+	var includes []string
+	for name := range fields.Include {
+		includes = append(includes, name)
+	}
+	sort.Strings(includes)
+	return []openapi.Param{
+		{Name: "fields", Description: "只返回所选字段, 逗号分隔, 可选: " + strings.Join(fields.Select, ", ")},
+		{Name: "include", Description: "同时返回的关联, 逗号分隔, 可选: " + strings.Join(includes, ", ")},
+	}
+}
+
+// BuildAPIDoc 由已注册的路由生成接口文档, problems 为没有说明的路由或没有对应路由的说明
+func BuildAPIDoc(engine *gin.Engine) (doc *openapi.Document, problems []string) {
+	docs := routeDocs
+	if config.AppConfig.AppRunMode == "dev" {
+		docs = append(append([]openapi.Route{}, routeDocs...), devRouteDocs...)
+	}
+	info := openapi.Info{Title: config.AppConfig.AppName + " API", Version: "v1",
+		Description: "响应为 {code, message, message_key, data}, 错误时另有 error_code/request_id/details, 见 Error"}
+	return openapi.Build(info, dto.SchemaRules(), engine.Routes(), docs)
+}
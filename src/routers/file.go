@@ -0,0 +1,27 @@
+package routers
+
+import (
+	"github.com/sulin2018/go-web-base/src/controllers"
+	"github.com/sulin2018/go-web-base/src/middleware"
+)
+
+func AddFileV1Router() {
+	// 签名地址自带鉴权, 无需登录
+	apiv1.GET("/storage", controllers.StorageSignedGet)
+
+	fileApi := g.Group("/api/v1")
+	fileApi.Use(middleware.LoginPermissionMiddleware())
+
+	fileApi.POST("/file", controllers.FilePost)
+	fileApi.GET("/file/:id", controllers.FileGet)
+	fileApi.GET("/file/:id/download", controllers.FileDownload)
+	fileApi.GET("/file/:id/url", controllers.FileURLGet)
+	fileApi.DELETE("/file/:id", controllers.FileDelete)
+	fileApi.GET("/files", controllers.FilesGet)
+
+	// 分片上传
+	fileApi.POST("/upload", controllers.UploadPost)
+	fileApi.GET("/upload/:id", controllers.UploadGet)
+	fileApi.PATCH("/upload/:id", controllers.UploadPatch)
+	fileApi.DELETE("/upload/:id", controllers.UploadDelete)
+}
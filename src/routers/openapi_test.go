@@ -0,0 +1,25 @@
+package routers
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sulin2018/go-web-base/src/app/config"
+)
+
+// 每个注册的路由都需要在 routeDocs 中有说明, 每个说明都需要对应注册的路由
+func TestAPIDocCoversRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(mode string) { config.AppConfig.AppRunMode = mode }(config.AppConfig.AppRunMode)
+
+	for _, mode := range []string{"pro", "dev"} {
+		config.AppConfig.AppRunMode = mode
+		doc, problems := BuildAPIDoc(InitGinEngine())
+		for _, problem := range problems {
+			t.Errorf("%s mode: %s", mode, problem)
+		}
+		if len(doc.Paths) == 0 {
+			t.Errorf("%s mode: no paths documented", mode)
+		}
+	}
+}
@@ -15,6 +15,22 @@ func AddUserV1Router() {
 	userApi.PATCH("/user/:id", controllers.UserPatch)
 	userApi.DELETE("/user/:id", controllers.UserDelete)
 	userApi.GET("/users", controllers.UsersGet)
+	userApi.POST("/users/import", controllers.UsersImport)
+	userApi.GET("/users/export", controllers.UsersExport)
+	userApi.POST("/users/activate", controllers.UsersActivate)
+	userApi.POST("/users/deactivate", controllers.UsersDeactivate)
+	userApi.POST("/user/:id/restore", controllers.UserRestore)
+	userApi.DELETE("/user/:id/purge", middleware.SuperuserMiddleware(), controllers.UserPurge)
+	userApi.POST("/user/:id/avatar", controllers.UserAvatarPost)
+	userApi.DELETE("/user/:id/avatar", controllers.UserAvatarDelete)
+	userApi.POST("/user/:id/password", controllers.UserPasswordPost)
+	userApi.DELETE("/user/:id/sessions", controllers.UserSessionsDelete)
+	userApi.POST("/user/:id/groups/:gid", controllers.UserGroupPost)
+	userApi.DELETE("/user/:id/groups/:gid", controllers.UserGroupDelete)
+	userApi.POST("/user/:id/permissions/:pid", controllers.UserPermissionPost)
+	userApi.DELETE("/user/:id/permissions/:pid", controllers.UserPermissionDelete)
+	userApi.GET("/user/:id/history", controllers.UserHistoryGet)
+	userApi.POST("/user/:id/history/:revision/revert", controllers.UserRevert)
 
 	// group
 	userApi.GET("/group/:id", controllers.GroupGet)
@@ -23,6 +39,15 @@ func AddUserV1Router() {
 	userApi.PUT("/group/:id", controllers.GroupPut)
 	userApi.POST("/group", controllers.GroupPost)
 	userApi.GET("/groups", controllers.GroupsGet)
+	userApi.POST("/group/:id/users", controllers.GroupUsersPost)
+	userApi.DELETE("/group/:id/users", controllers.GroupUsersDelete)
+	userApi.POST("/group/:id/users/:uid", controllers.GroupUserPost)
+	userApi.DELETE("/group/:id/users/:uid", controllers.GroupUserDelete)
+	userApi.POST("/group/:id/permissions/:pid", controllers.GroupPermissionPost)
+	userApi.DELETE("/group/:id/permissions/:pid", controllers.GroupPermissionDelete)
+	userApi.POST("/group/:id/restore", controllers.GroupRestore)
+	userApi.GET("/group/:id/history", controllers.GroupHistoryGet)
+	userApi.POST("/group/:id/history/:revision/revert", controllers.GroupRevert)
 
 	// permission
 	userApi.GET("/permission/:id", controllers.PermissionGet)
@@ -30,4 +55,9 @@ func AddUserV1Router() {
 	userApi.DELETE("/permission/:id", controllers.PermissionDelete)
 	userApi.POST("/permission", controllers.PermissionPost)
 	userApi.GET("/permissions", controllers.PermissionsGet)
+	userApi.POST("/permission/:id/groups", controllers.PermissionGroupsPost)
+	userApi.DELETE("/permission/:id/groups", controllers.PermissionGroupsDelete)
+	userApi.POST("/permission/:id/restore", controllers.PermissionRestore)
+	userApi.GET("/permission/:id/history", controllers.PermissionHistoryGet)
+	userApi.POST("/permission/:id/history/:revision/revert", controllers.PermissionRevert)
 }
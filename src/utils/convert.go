@@ -6,7 +6,7 @@ type StrTo string
 
 func (f StrTo) Exist() bool {
 	// 0x1E = 30  RS  (record separator)
-	return string(f) != string(0x1E)
+	return string(f) != string(rune(0x1E))
 }
 
 func (f StrTo) String() string {
@@ -2,17 +2,21 @@ package utils
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
 	"mime/multipart"
 	"os"
 	"path"
 )
 
-// GetSize get the file size
-func GetSize(f multipart.File) (int, error) {
-	content, err := ioutil.ReadAll(f)
+// GetSize get the file size without reading the content
+func GetSize(f multipart.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(0, io.SeekStart)
 
-	return len(content), err
+	return size, err
 }
 
 // GetExt get the file ext
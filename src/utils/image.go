@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+)
+
+// Thumbnail center-crop the image to a square and scale it to size x size
+func Thumbnail(src image.Image, size int) *image.NRGBA {
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Point{
+		X: b.Min.X + (b.Dx()-side)/2,
+		Y: b.Min.Y + (b.Dy()-side)/2,
+	})
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0 := crop.Min.Y + y*side/size
+		y1 := crop.Min.Y + (y+1)*side/size
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0 := crop.Min.X + x*side/size
+			x1 := crop.Min.X + (x+1)*side/size
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			dst.SetNRGBA(x, y, averageColor(src, image.Rect(x0, y0, x1, y1)))
+		}
+	}
+	return dst
+}
+
+// averageColor box filter, 缩小时取区域平均值, 放大时退化为最近邻
+func averageColor(src image.Image, r image.Rectangle) color.NRGBA {
+	var sr, sg, sb, sa, n uint64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+			sr += uint64(c.R)
+			sg += uint64(c.G)
+			sb += uint64(c.B)
+			sa += uint64(c.A)
+			n++
+		}
+	}
+	return color.NRGBA{R: uint8(sr / n), G: uint8(sg / n), B: uint8(sb / n), A: uint8(sa / n)}
+}